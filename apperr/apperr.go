@@ -0,0 +1,131 @@
+// Package apperr defines the errors the API answers with. Every error returned
+// by a handler is mapped to one of them and written as a JSON body carrying its
+// stable code, so the dashboard can react to each case.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Code identifies an error in responses. Codes are part of the API and must
+// not change.
+type Code string
+
+const (
+	CodeBadRequest          Code = "bad_request"
+	CodeUnauthorized        Code = "unauthorized"
+	CodeTokenExpired        Code = "token_expired"
+	CodeForbidden           Code = "forbidden"
+	CodeNotFound            Code = "not_found"
+	CodeConflict            Code = "conflict"
+	CodeGone                Code = "gone"
+	CodeUpstreamRateLimited Code = "upstream_rate_limited"
+	CodeUpstreamUnavailable Code = "upstream_unavailable"
+	CodeInternal            Code = "internal"
+)
+
+// Error is an error with the status and message it is answered with. Errors
+// created with Define or Wrap match their kind with errors.Is, so
+// errors.Is(err, ErrTokenExpired) holds for every kind of expired token.
+type Error struct {
+	Code    Code
+	Status  int
+	Message string
+	kind    *Error
+	err     error
+}
+
+var (
+	ErrBadRequest          = &Error{Code: CodeBadRequest, Status: http.StatusBadRequest, Message: "bad request"}
+	ErrUnauthorized        = &Error{Code: CodeUnauthorized, Status: http.StatusUnauthorized, Message: "unauthorized"}
+	ErrTokenExpired        = &Error{Code: CodeTokenExpired, Status: http.StatusUnauthorized, Message: "spotify token expired"}
+	ErrForbidden           = &Error{Code: CodeForbidden, Status: http.StatusForbidden, Message: "forbidden"}
+	ErrNotFound            = &Error{Code: CodeNotFound, Status: http.StatusNotFound, Message: "not found"}
+	ErrConflict            = &Error{Code: CodeConflict, Status: http.StatusConflict, Message: "conflict"}
+	ErrGone                = &Error{Code: CodeGone, Status: http.StatusGone, Message: "gone"}
+	ErrUpstreamRateLimited = &Error{Code: CodeUpstreamRateLimited, Status: http.StatusTooManyRequests, Message: "upstream rate limit reached"}
+	ErrUpstreamUnavailable = &Error{Code: CodeUpstreamUnavailable, Status: http.StatusBadGateway, Message: "upstream unavailable"}
+	ErrInternal            = &Error{Code: CodeInternal, Status: http.StatusInternalServerError, Message: "internal error"}
+)
+
+// Define creates a more specific kind of error, e.g. an expired session is a
+// kind of ErrTokenExpired with its own message.
+func Define(kind *Error, message string) *Error {
+	return &Error{Code: kind.Code, Status: kind.Status, Message: message, kind: kind}
+}
+
+// Wrap marks err as being of kind, nil when err is nil.
+func Wrap(kind *Error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: kind.Code, Status: kind.Status, Message: kind.Message, kind: kind, err: err}
+}
+
+// BadRequest reports invalid input, message is shown to the caller as is.
+func BadRequest(message string) error {
+	return Define(ErrBadRequest, message)
+}
+
+// FromStatus returns the kind of error of an upstream HTTP status, nil for
+// statuses that do not map to one.
+func FromStatus(status int) *Error {
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrTokenExpired
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusTooManyRequests:
+		return ErrUpstreamRateLimited
+	case status >= http.StatusInternalServerError:
+		return ErrUpstreamUnavailable
+	default:
+		return nil
+	}
+}
+
+// kinds are the errors From falls back to matching with errors.Is, for errors
+// such as those of upstream clients that only match a kind through their Is
+// method.
+var kinds = []*Error{
+	ErrBadRequest,
+	ErrUnauthorized,
+	ErrTokenExpired,
+	ErrForbidden,
+	ErrNotFound,
+	ErrConflict,
+	ErrGone,
+	ErrUpstreamRateLimited,
+	ErrUpstreamUnavailable,
+}
+
+// From returns the outermost *Error in err's chain, else the first kind err
+// matches with errors.Is, ErrInternal when there is none.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return ErrInternal
+}
+
+func (e *Error) Error() string {
+	if e.err != nil {
+		return e.Message + ": " + e.err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.kind != nil && (target == e.kind || errors.Is(e.kind, target))
+}
@@ -0,0 +1,43 @@
+package apperr_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+	"github.com/MinhPhu0304/spotify/client/lastfm"
+)
+
+func TestFrom(t *testing.T) {
+	expired := apperr.Define(apperr.ErrTokenExpired, "session expired")
+	tests := []struct {
+		name   string
+		err    error
+		want   *apperr.Error
+		status int
+	}{
+		{"kind", apperr.ErrNotFound, apperr.ErrNotFound, http.StatusNotFound},
+		{"defined kind", pkgerrors.Wrap(expired, "failed to get session"), expired, http.StatusUnauthorized},
+		{"wrapped", apperr.Wrap(apperr.ErrUpstreamUnavailable, errors.New("timeout")), nil, http.StatusBadGateway},
+		{"bad request", apperr.BadRequest("invalid limit"), nil, http.StatusBadRequest},
+		{"last.fm unknown user", pkgerrors.Wrap(&lastfm.Error{Method: "user.getrecenttracks", Code: 6}, "failed to get scrobbles"), apperr.ErrNotFound, http.StatusNotFound},
+		{"last.fm rate limit", pkgerrors.Wrap(&lastfm.Error{Method: "artist.getinfo", Code: 29}, "failed to get bio"), apperr.ErrUpstreamRateLimited, http.StatusTooManyRequests},
+		{"last.fm offline", &lastfm.Error{Method: "artist.getinfo", Code: 11}, apperr.ErrUpstreamUnavailable, http.StatusBadGateway},
+		{"last.fm other error", &lastfm.Error{Method: "artist.getinfo", Code: 10}, apperr.ErrInternal, http.StatusInternalServerError},
+		{"plain error", errors.New("boom"), apperr.ErrInternal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := apperr.From(tt.err)
+			if tt.want != nil && got != tt.want {
+				t.Errorf("From() = %v, want %v", got, tt.want)
+			}
+			if got.Status != tt.status {
+				t.Errorf("From().Status = %d, want %d", got.Status, tt.status)
+			}
+		})
+	}
+}
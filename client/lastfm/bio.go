@@ -0,0 +1,123 @@
+package lastfm
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+// Bio is a last.fm biography or wiki converted from its HTML.
+type Bio struct {
+	Paragraphs  []string        `json:"paragraphs"`
+	Summary     string          `json:"summary"`
+	Links       []types.BioLink `json:"links"`
+	Attribution string          `json:"attribution,omitempty"`
+}
+
+// SanitizeBio converts the content and summary of a last.fm biography or wiki.
+// Last.fm ends both with a "Read more on Last.fm" link, the content being
+// followed by the license of the user contributed text. The link is dropped
+// and the license is returned as the attribution. The summary falls back to
+// the first paragraph.
+func SanitizeBio(content string, summary string) Bio {
+	text, links, attribution := parseBioHTML(content)
+	bio := Bio{
+		Paragraphs:  make([]string, 0),
+		Links:       links,
+		Attribution: collapseSpaces(strings.TrimLeft(attribution, ". \n")),
+	}
+	for _, p := range strings.Split(text, "\n") {
+		if p = collapseSpaces(p); p != "" {
+			bio.Paragraphs = append(bio.Paragraphs, p)
+		}
+	}
+
+	summaryText, _, _ := parseBioHTML(summary)
+	bio.Summary = collapseSpaces(summaryText)
+	if bio.Summary == "" && len(bio.Paragraphs) > 0 {
+		bio.Summary = bio.Paragraphs[0]
+	}
+	return bio
+}
+
+// parseBioHTML returns the text of s with entities decoded, its links and the
+// text following the read more link.
+func parseBioHTML(s string) (text string, links []types.BioLink, attribution string) {
+	links = make([]types.BioLink, 0)
+	seen := make(map[string]bool)
+	var body, after strings.Builder
+	out := &body
+	var link *types.BioLink
+	var linkText strings.Builder
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return body.String(), links, after.String()
+		case html.TextToken:
+			if link != nil {
+				linkText.Write(z.Text())
+			} else {
+				out.Write(z.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "a":
+				link = &types.BioLink{}
+				linkText.Reset()
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					if string(key) == "href" {
+						link.URL = string(val)
+					}
+				}
+			case "br", "p":
+				out.WriteString("\n")
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) != "a" || link == nil {
+				continue
+			}
+			link.Name = collapseSpaces(linkText.String())
+			if isReadMore(*link) {
+				out = &after
+			} else {
+				out.WriteString(linkText.String())
+				if link.URL != "" && !seen[link.URL] {
+					seen[link.URL] = true
+					links = append(links, *link)
+				}
+			}
+			link = nil
+		}
+	}
+}
+
+// isReadMore tells the link to the artist's last.fm page ending every bio,
+// whose text is translated along with the bio. Links in the text may mention
+// last.fm too, but point below the artist page, e.g. to its wiki or albums.
+func isReadMore(link types.BioLink) bool {
+	if !strings.Contains(strings.ToLower(link.Name), "last.fm") {
+		return false
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil || !strings.HasSuffix(u.Hostname(), "last.fm") {
+		return false
+	}
+	// /music/<artist> or /<lang>/music/<artist>
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) == 3 {
+		segments = segments[1:]
+	}
+	return len(segments) == 2 && segments[0] == "music"
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
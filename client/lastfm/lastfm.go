@@ -1,22 +1,72 @@
 package lastfm
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/MinhPhu0304/spotify/apperr"
 	"github.com/MinhPhu0304/spotify/trace"
 	"github.com/MinhPhu0304/spotify/types/lastfm"
 )
 
 type LastFMClient interface {
-	GetArtistBio(ctx context.Context, name string) (lastfm.LastFMBio, error)
+	GetArtistBio(ctx context.Context, name string, mbid string, lang string) (lastfm.LastFMBio, error)
+	GetRecentTracks(ctx context.Context, user string, from time.Time, to time.Time, page int) (lastfm.RecentTracks, error)
+	GetSimilarArtists(ctx context.Context, artist string, limit int) (lastfm.SimilarArtists, error)
+	GetArtistTopTags(ctx context.Context, artist string) (lastfm.TopTags, error)
+	GetTrackInfo(ctx context.Context, artist string, track string, username string) (lastfm.TrackInfo, error)
+	GetAlbumInfo(ctx context.Context, artist string, album string) (lastfm.AlbumInfo, error)
+	GetTagTopArtists(ctx context.Context, tag string, limit int) (lastfm.TagTopArtists, error)
+}
+
+// recentTracksPageSize is the most scrobbles last.fm returns in a single page.
+const recentTracksPageSize = 200
+
+// Error codes of last.fm, see https://www.last.fm/api/errorcodes.
+const (
+	// errInvalidParameters is what unknown artists, tracks, albums and users
+	// are answered with.
+	errInvalidParameters = 6
+	errOperationFailed   = 8
+	errServiceOffline    = 11
+	errTemporaryError    = 16
+	errRateLimitExceeded = 29
+)
+
+// ErrNotFound is matched by errors.Is when last.fm does not know the artist,
+// track, album or user asked for.
+var ErrNotFound = apperr.Define(apperr.ErrNotFound, "not found on last.fm")
+
+// Error is an error answered by last.fm in its JSON error envelope.
+type Error struct {
+	Method  string
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("last.fm %s failed with error %d: %s", e.Method, e.Code, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch e.Code {
+	case errInvalidParameters:
+		return errors.Is(ErrNotFound, target)
+	case errRateLimitExceeded:
+		return target == apperr.ErrUpstreamRateLimited
+	case errOperationFailed, errServiceOffline, errTemporaryError:
+		return target == apperr.ErrUpstreamUnavailable
+	}
+	return false
 }
 
 type lastFMClient struct {
@@ -25,10 +75,8 @@ type lastFMClient struct {
 	client *http.Client
 }
 
-var linkRegex = regexp.MustCompile(`<a[^>]*>|<\/a>`)
-
 func Client(token string) LastFMClient {
-	c := trace.DefaultTracedClient()
+	c := trace.DefaultTracedClient(trace.RetryWhen(isRateLimited))
 	return &lastFMClient{
 		token:  token,
 		url:    "http://ws.audioscrobbler.com/2.0",
@@ -36,37 +84,173 @@ func Client(token string) LastFMClient {
 	}
 }
 
-func (l *lastFMClient) bioURL(artist string) string {
-	name := url.QueryEscape(artist)
-	return fmt.Sprintf("%s?method=artist.getinfo&artist=%s&api_key=%s&format=json", l.url, name, l.token)
+func (l *lastFMClient) methodURL(method string, params url.Values) string {
+	params.Set("method", method)
+	params.Set("api_key", l.token)
+	params.Set("format", "json")
+	return l.url + "?" + params.Encode()
+}
+
+// isRateLimited tells whether last.fm answered with its rate limit error,
+// which does not always come with a 429.
+func isRateLimited(resp *http.Response) bool {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	var envelope lastfm.Error
+	return err == nil && json.Unmarshal(body, &envelope) == nil && envelope.Code == errRateLimitExceeded
 }
 
-func (l *lastFMClient) GetArtistBio(ctx context.Context, name string) (lastfm.LastFMBio, error) {
-	url := l.bioURL(name)
-	resp, err := l.client.Get(url)
+// get calls a last.fm API method and decodes its response into T. Failures
+// come back as an *Error whatever the HTTP status was.
+func get[T any](ctx context.Context, l *lastFMClient, method string, params url.Values) (T, error) {
+	var res T
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.methodURL(method, params), nil)
+	if err != nil {
+		return res, errors.Wrap(err, "failed to create HTTP request")
+	}
+	resp, err := l.client.Do(req)
 	if err != nil {
-		return lastfm.LastFMBio{}, errors.Wrap(err, "failed to send HTTP GET request")
+		return res, apperr.Wrap(apperr.ErrUpstreamUnavailable, errors.Wrap(err, "failed to send HTTP GET request"))
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return res, errors.Wrap(err, "failed to read HTTP response body")
+	}
+
+	var envelope lastfm.Error
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Code != 0 {
+		return res, &Error{Method: method, Code: envelope.Code, Message: envelope.Message}
+	}
 	if resp.StatusCode != http.StatusOK {
-		return lastfm.LastFMBio{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return res, upstreamStatusError(resp.StatusCode)
 	}
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &res); err != nil {
+		return res, errors.Wrap(err, "failed to unmarshal JSON")
+	}
+	return res, nil
+}
+
+// upstreamStatusError maps a failed status, a 401 comes from our API key and
+// not from the caller's token so it is reported as last.fm being unavailable.
+func upstreamStatusError(status int) error {
+	kind := apperr.FromStatus(status)
+	if kind == nil || kind == apperr.ErrTokenExpired {
+		kind = apperr.ErrUpstreamUnavailable
+	}
+	return apperr.Wrap(kind, fmt.Errorf("unexpected status code: %d", status))
+}
+
+// GetArtistBio returns the artist's bio in lang, an ISO 639-1 code. Last.fm
+// answers with an empty bio when it has no translation in that language. The
+// artist is looked up by mbid when set, as names are ambiguous.
+func (l *lastFMClient) GetArtistBio(ctx context.Context, name string, mbid string, lang string) (lastfm.LastFMBio, error) {
+	params := url.Values{"artist": {name}}
+	if mbid != "" {
+		params = url.Values{"mbid": {mbid}}
+	}
+	if lang != "" {
+		params.Set("lang", lang)
+	}
+	artist, err := get[lastfm.LastFMBio](ctx, l, "artist.getinfo", params)
 	if err != nil {
-		return lastfm.LastFMBio{}, errors.Wrap(err, "failed to read HTTP response body")
+		return lastfm.LastFMBio{}, err
+	}
+	if artist.Artist == nil || artist.Artist.Bio == nil {
+		return lastfm.LastFMBio{}, errors.Wrapf(ErrNotFound, "no bio for artist %q", name)
 	}
+	return artist, nil
+}
 
-	// Unmarshal the response body into an Artist struct
-	artist := lastfm.LastFMBio{}
-	err = json.Unmarshal(body, &artist)
+// GetRecentTracks returns a page of the user's scrobbles between from and to,
+// newest first. Pages start at 1. The track playing right now is listed on top
+// of the first page without a scrobble date, it is left out so every returned
+// track is an actual scrobble.
+func (l *lastFMClient) GetRecentTracks(ctx context.Context, user string, from time.Time, to time.Time, page int) (lastfm.RecentTracks, error) {
+	params := url.Values{
+		"user":  {user},
+		"limit": {strconv.Itoa(recentTracksPageSize)},
+		"page":  {strconv.Itoa(page)},
+	}
+	if !from.IsZero() {
+		params.Set("from", strconv.FormatInt(from.Unix(), 10))
+	}
+	if !to.IsZero() {
+		params.Set("to", strconv.FormatInt(to.Unix(), 10))
+	}
+	recent, err := get[lastfm.RecentTracks](ctx, l, "user.getrecenttracks", params)
 	if err != nil {
-		return lastfm.LastFMBio{}, errors.Wrap(err, "failed to unmarshal JSON")
+		return lastfm.RecentTracks{}, err
 	}
 
-	artist.Artist.Bio.Content = linkRegex.ReplaceAllString(artist.Artist.Bio.Content, "")
-	artist.Artist.Bio.Summary = linkRegex.ReplaceAllString(artist.Artist.Bio.Summary, "")
-	return artist, nil
+	scrobbles := recent.RecentTracks.Track[:0]
+	for _, t := range recent.RecentTracks.Track {
+		if t.IsNowPlaying() || t.Date == nil {
+			continue
+		}
+		scrobbles = append(scrobbles, t)
+	}
+	recent.RecentTracks.Track = scrobbles
+	return recent, nil
+}
+
+// GetSimilarArtists returns up to limit artists similar to artist, most similar first.
+func (l *lastFMClient) GetSimilarArtists(ctx context.Context, artist string, limit int) (lastfm.SimilarArtists, error) {
+	return get[lastfm.SimilarArtists](ctx, l, "artist.getsimilar", url.Values{
+		"artist":      {artist},
+		"limit":       {strconv.Itoa(limit)},
+		"autocorrect": {"1"},
+	})
+}
+
+// GetArtistTopTags returns the tags listeners gave artist, most used first.
+func (l *lastFMClient) GetArtistTopTags(ctx context.Context, artist string) (lastfm.TopTags, error) {
+	return get[lastfm.TopTags](ctx, l, "artist.gettoptags", url.Values{
+		"artist":      {artist},
+		"autocorrect": {"1"},
+	})
+}
+
+// GetTrackInfo returns the global statistics of a track, along with the
+// playcount and loved status of username when it is not empty.
+func (l *lastFMClient) GetTrackInfo(ctx context.Context, artist string, track string, username string) (lastfm.TrackInfo, error) {
+	params := url.Values{
+		"artist":      {artist},
+		"track":       {track},
+		"autocorrect": {"1"},
+	}
+	if username != "" {
+		params.Set("username", username)
+	}
+	info, err := get[lastfm.TrackInfo](ctx, l, "track.getinfo", params)
+	if err != nil {
+		return info, err
+	}
+	if info.Track == nil {
+		return info, errors.Wrapf(ErrNotFound, "no track %q by %q", track, artist)
+	}
+	return info, nil
+}
+
+func (l *lastFMClient) GetAlbumInfo(ctx context.Context, artist string, album string) (lastfm.AlbumInfo, error) {
+	info, err := get[lastfm.AlbumInfo](ctx, l, "album.getinfo", url.Values{
+		"artist":      {artist},
+		"album":       {album},
+		"autocorrect": {"1"},
+	})
+	if err == nil && info.Album == nil {
+		return info, errors.Wrapf(ErrNotFound, "no album %q by %q", album, artist)
+	}
+	return info, err
+}
+
+// GetTagTopArtists returns up to limit of the artists most tagged with tag.
+func (l *lastFMClient) GetTagTopArtists(ctx context.Context, tag string, limit int) (lastfm.TagTopArtists, error) {
+	return get[lastfm.TagTopArtists](ctx, l, "tag.gettopartists", url.Values{
+		"tag":   {tag},
+		"limit": {strconv.Itoa(limit)},
+	})
 }
@@ -0,0 +1,151 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+)
+
+// newTestClient stubs last.fm with a map of API method to response body,
+// answered with status. Unknown methods are answered with an empty object.
+func newTestClient(t *testing.T, status int, methods map[string]string) *lastFMClient {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if key := q.Get("api_key"); key != "key" {
+			t.Errorf("api_key = %q, want key", key)
+		}
+		if f := q.Get("format"); f != "json" {
+			t.Errorf("format = %q, want json", f)
+		}
+		body, ok := methods[q.Get("method")]
+		if !ok {
+			body = "{}"
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return &lastFMClient{token: "key", url: srv.URL, client: srv.Client()}
+}
+
+func TestGetErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		code   int
+		want   error
+	}{
+		{"not found", http.StatusOK, `{"error": 6, "message": "The artist you supplied could not be found"}`, errInvalidParameters, ErrNotFound},
+		{"rate limited", http.StatusOK, `{"error": 29, "message": "Rate Limit Exceeded"}`, errRateLimitExceeded, apperr.ErrUpstreamRateLimited},
+		{"offline", http.StatusOK, `{"error": 11, "message": "Service Offline"}`, errServiceOffline, apperr.ErrUpstreamUnavailable},
+		{"with failed status", http.StatusBadRequest, `{"error": 6, "message": "Track not found"}`, errInvalidParameters, apperr.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestClient(t, tt.status, map[string]string{"artist.getsimilar": tt.body})
+
+			_, err := l.GetSimilarArtists(context.Background(), "Radiohead", 5)
+			var lfmErr *Error
+			if !errors.As(err, &lfmErr) {
+				t.Fatalf("GetSimilarArtists() error = %v, want an *Error", err)
+			}
+			if lfmErr.Code != tt.code || lfmErr.Method != "artist.getsimilar" {
+				t.Errorf("error = %+v, want code %d of artist.getsimilar", lfmErr, tt.code)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("GetSimilarArtists() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFailedStatus(t *testing.T) {
+	l := newTestClient(t, http.StatusUnauthorized, map[string]string{"artist.gettoptags": "Unauthorized"})
+
+	_, err := l.GetArtistTopTags(context.Background(), "Radiohead")
+	if !errors.Is(err, apperr.ErrUpstreamUnavailable) {
+		t.Errorf("GetArtistTopTags() error = %v, want ErrUpstreamUnavailable", err)
+	}
+}
+
+func TestListShapes(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"array", `{"similarartists": {"artist": [{"name": "Portishead", "match": "1"}, {"name": "Thom Yorke", "match": "0.8"}]}}`, []string{"Portishead", "Thom Yorke"}},
+		{"single object", `{"similarartists": {"artist": {"name": "Portishead", "match": "1"}}}`, []string{"Portishead"}},
+		{"empty string", `{"similarartists": {"artist": ""}}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestClient(t, http.StatusOK, map[string]string{"artist.getsimilar": tt.body})
+
+			similar, err := l.GetSimilarArtists(context.Background(), "Radiohead", 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, a := range similar.SimilarArtists.Artist {
+				got = append(got, a.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("artists = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("artists = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackInfoEmptyTags(t *testing.T) {
+	l := newTestClient(t, http.StatusOK, map[string]string{
+		"track.getinfo": `{"track": {"name": "Reckoner", "listeners": "1000", "playcount": "5000", "toptags": "", "userplaycount": "12", "userloved": "1"}}`,
+	})
+
+	info, err := l.GetTrackInfo(context.Background(), "Radiohead", "Reckoner", "listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Track.TopTags.Tag) != 0 {
+		t.Errorf("tags = %v, want none", info.Track.TopTags.Tag)
+	}
+	if n, _ := info.Track.UserPlaycount.Int64(); n != 12 {
+		t.Errorf("userplaycount = %d, want 12", n)
+	}
+}
+
+// TestUnknownArtist checks that an unknown artist is an error rather than a
+// bio without an artist, which callers used to dereference.
+func TestUnknownArtist(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"error envelope", `{"error": 6, "message": "The artist you supplied could not be found", "links": []}`},
+		{"no artist", `{}`},
+		{"no bio", `{"artist": {"name": "Unknown"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestClient(t, http.StatusOK, map[string]string{"artist.getinfo": tt.body})
+
+			bio, err := l.GetArtistBio(context.Background(), "Unknown", "", "en")
+			if !errors.Is(err, ErrNotFound) || !errors.Is(err, apperr.ErrNotFound) {
+				t.Errorf("GetArtistBio() error = %v, want ErrNotFound", err)
+			}
+			if bio.Artist != nil {
+				t.Errorf("GetArtistBio() = %+v, want no artist", bio.Artist)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package musicbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+	"github.com/MinhPhu0304/spotify/trace"
+	"github.com/MinhPhu0304/spotify/types/musicbrainz"
+)
+
+// DefaultURL is the public MusicBrainz web service.
+const DefaultURL = "https://musicbrainz.org/ws/2"
+
+// userAgent identifies the application as the MusicBrainz API requires, anonymous
+// clients get throttled.
+const userAgent = "spotify-dashboard/1.0 (https://github.com/MinhPhu0304/spotify)"
+
+// MusicBrainz allows a request per second and client IP. Lookups wait in line
+// for at most maxQueueWait, they are made while an artist's bio is fetched.
+const (
+	requestsPerSecond = 1
+	maxQueueWait      = 2 * time.Second
+)
+
+// ErrNotFound is returned when MusicBrainz has no artist for the lookup.
+var ErrNotFound = apperr.Define(apperr.ErrNotFound, "not found on musicbrainz")
+
+type MusicBrainzClient interface {
+	// ArtistMBID resolves a Spotify artist to its MusicBrainz ID, first through
+	// the URL relation of the artist's Spotify page, then through the ISRCs of
+	// the artist's tracks.
+	ArtistMBID(ctx context.Context, spotifyArtistID string, name string, isrcs func() []string) (string, error)
+}
+
+type musicBrainzClient struct {
+	url    string
+	client *http.Client
+}
+
+// Client creates a MusicBrainz client against baseURL, DefaultURL when empty.
+// Pointing it to a local server lets tests stub MusicBrainz out.
+func Client(baseURL string) MusicBrainzClient {
+	if baseURL == "" {
+		baseURL = DefaultURL
+	}
+	return &musicBrainzClient{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		client: trace.DefaultTracedClient(trace.WithLimiter(maxQueueWait, trace.NewLimiter(requestsPerSecond, 1))),
+	}
+}
+
+func get[T any](ctx context.Context, m *musicBrainzClient, path string, params url.Values) (T, error) {
+	var res T
+	params.Set("fmt", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.url+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return res, errors.Wrap(err, "failed to create HTTP request")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return res, apperr.Wrap(apperr.ErrUpstreamUnavailable, errors.Wrap(err, "failed to send HTTP GET request"))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return res, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		// MusicBrainz answers 503 when its rate limit is exceeded
+		kind := apperr.ErrUpstreamUnavailable
+		if resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests {
+			kind = apperr.ErrUpstreamRateLimited
+		}
+		return res, apperr.Wrap(kind, fmt.Errorf("unexpected status code: %d", resp.StatusCode))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, errors.Wrap(err, "failed to unmarshal JSON")
+	}
+	return res, nil
+}
+
+// isrcLookups bounds how many ISRCs are tried, MusicBrainz allows a single
+// request per second.
+const isrcLookups = 3
+
+// ArtistMBID takes isrcs as a func since they are only needed, and so only
+// waited for, when the Spotify page is not linked in MusicBrainz.
+func (m *musicBrainzClient) ArtistMBID(ctx context.Context, spotifyArtistID string, name string, isrcs func() []string) (string, error) {
+	mbid, err := m.artistByURL(ctx, spotifyArtistID)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return mbid, err
+	}
+
+	codes := isrcs()
+	if len(codes) > isrcLookups {
+		codes = codes[:isrcLookups]
+	}
+	for _, isrc := range codes {
+		mbid, err := m.artistByISRC(ctx, isrc, name)
+		if err == nil || !errors.Is(err, ErrNotFound) {
+			return mbid, err
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (m *musicBrainzClient) artistByURL(ctx context.Context, spotifyArtistID string) (string, error) {
+	res, err := get[musicbrainz.URL](ctx, m, "/url", url.Values{
+		"resource": {"https://open.spotify.com/artist/" + spotifyArtistID},
+		"inc":      {"artist-rels"},
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, rel := range res.Relations {
+		if rel.Artist != nil && rel.Artist.ID != "" {
+			return rel.Artist.ID, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// artistByISRC picks the credited artist named like the Spotify artist, a
+// recording can credit several artists.
+func (m *musicBrainzClient) artistByISRC(ctx context.Context, isrc string, name string) (string, error) {
+	res, err := get[musicbrainz.ISRC](ctx, m, "/isrc/"+url.PathEscape(isrc), url.Values{
+		"inc": {"artist-credits"},
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, rec := range res.Recordings {
+		for _, credit := range rec.ArtistCredit {
+			if strings.EqualFold(credit.Artist.Name, name) || strings.EqualFold(credit.Name, name) {
+				return credit.Artist.ID, nil
+			}
+		}
+	}
+	return "", ErrNotFound
+}
@@ -0,0 +1,144 @@
+package musicbrainz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+)
+
+const (
+	radioheadSpotifyID = "4Z8W4fKeB5YxbusRsdQVPb"
+	radioheadMBID      = "a74b1b7f-71a5-4011-9441-d0b5e4122711"
+)
+
+const urlRelation = `{
+	"id": "9ab1c2bd-7a1b-4d6c-8a0b-2a6f0c3b4e5f",
+	"resource": "https://open.spotify.com/artist/4Z8W4fKeB5YxbusRsdQVPb",
+	"relations": [
+		{
+			"type": "free streaming",
+			"target-type": "artist",
+			"direction": "backward",
+			"artist": {"id": "a74b1b7f-71a5-4011-9441-d0b5e4122711", "name": "Radiohead", "sort-name": "Radiohead"}
+		}
+	]
+}`
+
+const isrcRecordings = `{
+	"isrc": "GBAYE0601498",
+	"recordings": [
+		{
+			"id": "6f0a3f41-0d6f-4a1d-9c4f-3c4c3e0c7b2a",
+			"title": "Nude",
+			"artist-credit": [
+				{"name": "Thom Yorke", "joinphrase": " & ", "artist": {"id": "8ed2e0b3-aa4c-4e13-bec3-dc7393421e61", "name": "Thom Yorke"}},
+				{"name": "Radiohead", "joinphrase": "", "artist": {"id": "a74b1b7f-71a5-4011-9441-d0b5e4122711", "name": "Radiohead"}}
+			]
+		}
+	]
+}`
+
+const notFound = `{"error": "Not Found", "help": "For usage, please see: https://musicbrainz.org/development/mmd"}`
+
+// newTestClient stubs MusicBrainz with routes, a map of request path to
+// response body, and records the paths requested. Unknown paths are 404s.
+func newTestClient(t *testing.T, routes map[string]string) (MusicBrainzClient, *[]string) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if ua := r.Header.Get("User-Agent"); ua != userAgent {
+			t.Errorf("User-Agent = %q, want %q", ua, userAgent)
+		}
+		if f := r.URL.Query().Get("fmt"); f != "json" {
+			t.Errorf("fmt = %q, want json", f)
+		}
+		if r.URL.Path == "/url" && r.URL.Query().Get("resource") != "https://open.spotify.com/artist/"+radioheadSpotifyID {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(notFound))
+			return
+		}
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			body = notFound
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	// without the limiter of Client, tests would wait a second per lookup
+	return &musicBrainzClient{url: srv.URL, client: srv.Client()}, &requested
+}
+
+func noISRCs(t *testing.T) func() []string {
+	return func() []string {
+		t.Error("ISRCs asked for although the URL relation resolves the artist")
+		return nil
+	}
+}
+
+func TestArtistMBIDByURL(t *testing.T) {
+	mb, requested := newTestClient(t, map[string]string{"/url": urlRelation})
+
+	mbid, err := mb.ArtistMBID(context.Background(), radioheadSpotifyID, "Radiohead", noISRCs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mbid != radioheadMBID {
+		t.Errorf("ArtistMBID() = %q, want %q", mbid, radioheadMBID)
+	}
+	if len(*requested) != 1 {
+		t.Errorf("requested %q, want only the URL lookup", *requested)
+	}
+}
+
+func TestArtistMBIDByISRC(t *testing.T) {
+	mb, requested := newTestClient(t, map[string]string{"/isrc/GBAYE0601498": isrcRecordings})
+
+	isrcs := func() []string { return []string{"GBAYE0000000", "GBAYE0601498", "GBAYE0601499"} }
+	mbid, err := mb.ArtistMBID(context.Background(), "0000000000000000000000", "radiohead", isrcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the recording credits Thom Yorke too, the artist is picked by name
+	if mbid != radioheadMBID {
+		t.Errorf("ArtistMBID() = %q, want %q", mbid, radioheadMBID)
+	}
+	want := []string{"/url", "/isrc/GBAYE0000000", "/isrc/GBAYE0601498"}
+	if strings.Join(*requested, " ") != strings.Join(want, " ") {
+		t.Errorf("requested %q, want %q", *requested, want)
+	}
+}
+
+func TestArtistMBIDNotFound(t *testing.T) {
+	mb, requested := newTestClient(t, map[string]string{"/isrc/GBAYE0601498": isrcRecordings})
+
+	isrcs := func() []string { return []string{"GBAYE0601498", "GBAYE0000001", "GBAYE0000002", "GBAYE0000003"} }
+	mbid, err := mb.ArtistMBID(context.Background(), "0000000000000000000000", "Portishead", isrcs)
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("ArtistMBID() error = %v, want ErrNotFound", err)
+	}
+	if mbid != "" {
+		t.Errorf("ArtistMBID() = %q, want none", mbid)
+	}
+	if len(*requested) != 1+isrcLookups {
+		t.Errorf("requested %q, want the URL and %d ISRC lookups", *requested, isrcLookups)
+	}
+}
+
+func TestArtistMBIDRateLimited(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	mb := Client(srv.URL + "/")
+
+	_, err := mb.ArtistMBID(context.Background(), radioheadSpotifyID, "Radiohead", noISRCs(t))
+	if !errors.Is(err, apperr.ErrUpstreamRateLimited) {
+		t.Errorf("ArtistMBID() error = %v, want ErrUpstreamRateLimited", err)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/MinhPhu0304/spotify/apperr"
 	"github.com/MinhPhu0304/spotify/client/lastfm"
+	"github.com/MinhPhu0304/spotify/client/musicbrainz"
 	"github.com/MinhPhu0304/spotify/repository"
+	"github.com/MinhPhu0304/spotify/trace"
 	"github.com/MinhPhu0304/spotify/types"
 	lastfmtype "github.com/MinhPhu0304/spotify/types/lastfm"
 	"github.com/getsentry/sentry-go"
@@ -19,43 +23,76 @@ func (s *Spotify) artist(ctx context.Context, artistID string, client *spotify.C
 		return artist, nil
 	}
 	artist, err := client.GetArtist(ctx, spotify.ID(artistID))
-	return artist, err
+	return artist, upstreamError(err, "failed to get spotify artist")
 }
 
-func (s *Spotify) Artist(ctx context.Context, token string, artistID string, lastFMClient lastfm.LastFMClient) (types.ArtistInfo, error) {
-	spotifyClient := s.clientWithTrace(ctx, token)
+// DefaultBioLang is the language bios fall back to when last.fm has no
+// translation.
+const DefaultBioLang = "en"
+
+// bioTimeout bounds how long an artist waits for their bio.
+const bioTimeout = 3 * time.Second
+
+// mbidTimeout bounds the MusicBrainz lookup made before the bio is fetched,
+// leaving last.fm the rest of bioTimeout to be asked by name instead.
+const mbidTimeout = time.Second
+
+func (s *Spotify) Artist(ctx context.Context, sessionID string, artistID string, lang string, lastFMClient lastfm.LastFMClient, musicBrainzClient musicbrainz.MusicBrainzClient) (types.ArtistInfo, error) {
+	spotifyClient, err := s.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return types.ArtistInfo{}, err
+	}
 	artist, err := s.artist(ctx, artistID, spotifyClient)
 
 	if err != nil {
-		return types.ArtistInfo{}, errors.Wrap(err, "failed to get spotify artist")
+		return types.ArtistInfo{}, err
+	}
+
+	// the bio is best effort, the artist is returned without it when last.fm
+	// or MusicBrainz are too slow or down
+	bioCtx, bioCancel := context.WithTimeout(ctx, bioTimeout)
+	defer bioCancel()
+	mbidCtx, mbidCancel := context.WithTimeout(bioCtx, mbidTimeout)
+	defer mbidCancel()
+
+	// the ISRCs of the top tracks are a fallback to resolve the artist in MusicBrainz
+	isrcsReady := make(chan []string, 1)
+	defer close(isrcsReady)
+	isrcs := func() []string {
+		select {
+		case codes := <-isrcsReady:
+			return codes
+		case <-mbidCtx.Done():
+			return nil
+		}
 	}
 
 	bio := lastfmtype.LastFMBio{}
+	bioLang := lang
+	var errBio error
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer sentry.RecoverWithContext(ctx)
-		if abio, err := s.repo.GetArtistBio(artistID); errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrInvalidType) {
-			bio, err = lastFMClient.GetArtistBio(ctx, artist.Name)
-			if err != nil {
-				sentry.CaptureException(err)
-			}
-			s.repo.InsertArtistBio(&bio, artistID)
-		} else {
-			bio = *abio
+		mbid := s.artistMBID(mbidCtx, musicBrainzClient, artist, isrcs)
+		bio, errBio = s.artistBio(bioCtx, lastFMClient, artistID, artist.Name, mbid, lang)
+		if errBio == nil && !hasBio(bio) && lang != DefaultBioLang {
+			bio, errBio = s.artistBio(bioCtx, lastFMClient, artistID, artist.Name, mbid, DefaultBioLang)
+			bioLang = DefaultBioLang
 		}
 	}()
 
-	u, err := s.currentUser(ctx, spotifyClient, token)
+	u, err := s.currentUser(ctx, spotifyClient, sessionID)
 	if err != nil {
 		return types.ArtistInfo{}, errors.Wrap(err, "failed to get user info")
 	}
 
 	topTracks, err := spotifyClient.GetArtistsTopTracks(ctx, spotify.ID(artistID), u.Country)
 	if err != nil {
-		return types.ArtistInfo{}, errors.Wrap(err, "failed to get spotify artist top tracks")
+		return types.ArtistInfo{}, upstreamError(err, "failed to get spotify artist top tracks")
 	}
+	isrcsReady <- trackISRCs(topTracks)
 
 	f := make(map[string]spotify.AudioFeatures)
 	var errF error
@@ -69,23 +106,117 @@ func (s *Spotify) Artist(ctx context.Context, token string, artistID string, las
 	wg.Wait()
 
 	if errF != nil {
-		return types.ArtistInfo{}, errors.Wrap(err, "failed to get audio features")
+		return types.ArtistInfo{}, upstreamError(errF, "failed to get audio features")
 	}
 
-	return types.ArtistInfo{
+	info := types.ArtistInfo{
 		Artirst:       *artist,
 		TopTracks:     topTracks,
 		AudioFeatures: f,
-		Bio:           strings.Split(bio.Artist.Bio.Content, "\n"),
-	}, nil
+		Degraded:      errBio != nil,
+	}
+	if hasBio(bio) {
+		sanitized := lastfm.SanitizeBio(bio.Artist.Bio.Content, bio.Artist.Bio.Summary)
+		info.Bio = sanitized.Paragraphs
+		info.BioSummary = sanitized.Summary
+		info.BioLinks = sanitized.Links
+		info.BioAttribution = sanitized.Attribution
+		info.BioLang = bioLang
+	}
+	return info, nil
+}
+
+// artistMBID resolves the artist's MusicBrainz ID through the cache. Artists
+// MusicBrainz does not know are cached with an empty ID, an empty ID is also
+// returned when MusicBrainz is slow, busy or down.
+func (s *Spotify) artistMBID(ctx context.Context, musicBrainzClient musicbrainz.MusicBrainzClient, artist *spotify.FullArtist, isrcs func() []string) string {
+	artistID := artist.ID.String()
+	if mbid, err := s.repo.GetArtistMBID(artistID); err == nil || errors.Is(err, repository.ErrStale) {
+		return mbid
+	}
+	mbid, err := musicBrainzClient.ArtistMBID(ctx, artistID, artist.Name, isrcs)
+	switch {
+	case err == nil, errors.Is(err, musicbrainz.ErrNotFound):
+		s.repo.InsertArtistMBID(artistID, mbid)
+	case errors.Is(err, trace.ErrCircuitOpen), errors.Is(err, apperr.ErrUpstreamRateLimited), ctx.Err() != nil:
+		// expected under load, the bio is looked up by name
+	default:
+		sentry.CaptureException(err)
+	}
+	return mbid
+}
+
+func trackISRCs(tracks []spotify.FullTrack) []string {
+	isrcs := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		if isrc := t.ExternalIDs["isrc"]; isrc != "" {
+			isrcs = append(isrcs, isrc)
+		}
+	}
+	return isrcs
+}
+
+// artistBio returns the artist's last.fm bio in lang, through the cache. The
+// artist is looked up by mbid when known, by name otherwise. An error means
+// last.fm could not be reached.
+func (s *Spotify) artistBio(ctx context.Context, lastFMClient lastfm.LastFMClient, artistID string, name string, mbid string, lang string) (lastfmtype.LastFMBio, error) {
+	if abio, err := s.repo.GetArtistBio(artistID, lang); errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrInvalidType) {
+		bio, err := lastFMClient.GetArtistBio(ctx, name, mbid, lang)
+		if mbid != "" && errors.Is(err, lastfm.ErrNotFound) {
+			bio, err = lastFMClient.GetArtistBio(ctx, name, "", lang)
+		}
+		switch {
+		case err == nil, errors.Is(err, lastfm.ErrNotFound):
+			// artists unknown to last.fm are cached too so they are not looked up again
+			s.repo.InsertArtistBio(&bio, artistID, lang)
+		default:
+			if !errors.Is(err, trace.ErrCircuitOpen) {
+				sentry.CaptureException(err)
+			}
+			return lastfmtype.LastFMBio{}, err
+		}
+		return bio, nil
+	} else if abio != nil {
+		return *abio, nil
+	}
+	return lastfmtype.LastFMBio{}, nil
+}
+
+func hasBio(bio lastfmtype.LastFMBio) bool {
+	return bio.Artist != nil && bio.Artist.Bio != nil && strings.TrimSpace(bio.Artist.Bio.Content) != ""
 }
 
-func (s *Spotify) currentUser(ctx context.Context, client *spotify.Client, token string) (*spotify.PrivateUser, error) {
-	u, err := s.repo.GetUser(token)
-	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrInvalidType) {
-		user, err := client.CurrentUser(ctx)
-		s.repo.InsertUser(token, user, nil) // not the end of th world if repo fail to insert
-		return user, err
+// currentUser resolves the session to its Spotify user. The session to user
+// mapping is cached briefly so the user's own cache entries can be keyed by the
+// stable user ID rather than by anything derived from the session.
+func (s *Spotify) currentUser(ctx context.Context, client *spotify.Client, sessionID string) (*spotify.PrivateUser, error) {
+	if userID, err := s.repo.GetSessionUser(sessionID); err == nil {
+		if u, err := s.repo.GetUser(userID); err == nil {
+			return u, nil
+		}
+	}
+	user, err := client.CurrentUser(ctx)
+	if err != nil {
+		return nil, upstreamError(err, "failed to get current user")
+	}
+	// not the end of th world if repo fail to insert
+	s.repo.InsertSessionUser(sessionID, user.ID)
+	s.repo.InsertUser(user.ID, user, nil)
+	return user, nil
+}
+
+// UserID returns the Spotify user ID behind the session.
+func (s *Spotify) UserID(ctx context.Context, sessionID string) (string, error) {
+	if userID, err := s.repo.GetSessionUser(sessionID); err == nil {
+		return userID, nil
+	}
+	client, err := s.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	u, err := s.currentUser(ctx, client, sessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user info")
 	}
-	return u, nil
+	return u.ID, nil
 }
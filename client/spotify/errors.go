@@ -0,0 +1,76 @@
+package spotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+)
+
+// upstreamError wraps an error of the Spotify API with message and marks it
+// with the apperr kind matching its status, or as unavailable when Spotify
+// could not be reached.
+func upstreamError(err error, message string) error {
+	if err == nil {
+		return nil
+	}
+	wrapped := errors.Wrap(err, message)
+	var kind *apperr.Error
+	if errors.As(err, &kind) {
+		// failed in our own transport, e.g. queued for too long
+		return wrapped
+	}
+	var serr spotify.Error
+	if errors.As(err, &serr) {
+		if kind := apperr.FromStatus(serr.Status); kind != nil {
+			return apperr.Wrap(kind, wrapped)
+		}
+		return wrapped
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return apperr.Wrap(apperr.ErrUpstreamUnavailable, wrapped)
+	}
+	return wrapped
+}
+
+// errorBodyTransport rewrites error responses whose body is not a Spotify error
+// object carrying the status, e.g. empty ones or a gateway's HTML page, into
+// one. zmb3/spotify reports those as plain errors that upstreamError could not
+// map otherwise.
+type errorBodyTransport struct {
+	http.RoundTripper
+}
+
+func (t errorBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read spotify error response")
+	}
+	var e struct {
+		E spotify.Error `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil || e.E.Status == 0 {
+		e.E.Status = resp.StatusCode
+		if e.E.Message == "" {
+			e.E.Message = "spotify: " + http.StatusText(resp.StatusCode)
+		}
+		body, _ = json.Marshal(e)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
@@ -0,0 +1,45 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+)
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   *apperr.Error
+	}{
+		{"spotify error", http.StatusNotFound, `{"error":{"status":404,"message":"Non existing id"}}`, apperr.ErrNotFound},
+		{"empty body", http.StatusUnauthorized, ``, apperr.ErrTokenExpired},
+		{"gateway page", http.StatusBadGateway, `<html><body>502 Bad Gateway</body></html>`, apperr.ErrUpstreamUnavailable},
+		{"error without status", http.StatusTooManyRequests, `{"error":{"message":"API rate limit exceeded"}}`, apperr.ErrUpstreamRateLimited},
+		{"other error object", http.StatusServiceUnavailable, `{"error":"temporarily_unavailable"}`, apperr.ErrUpstreamUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			httpClient := &http.Client{Transport: errorBodyTransport{http.DefaultTransport}}
+			client := spotify.New(httpClient, spotify.WithBaseURL(srv.URL+"/"))
+
+			_, err := client.GetTrack(context.Background(), "6LgJvl0Xdtc73RJ1mmpotq")
+			err = upstreamError(err, "fail to get spotify track")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v (%s), want %s", err, apperr.From(err).Code, tt.want.Code)
+			}
+		})
+	}
+}
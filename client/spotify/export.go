@@ -0,0 +1,70 @@
+package spotify
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+// minStreamMs is how long a track has to be played for Spotify to count it as
+// a stream, skipped tracks are in the export too.
+const minStreamMs = 30 * 1000
+
+// exportedStream is an entry of the Streaming_History_Audio_*.json files of a
+// Spotify "Extended streaming history" data export. Only the fields needed for
+// a play are decoded.
+type exportedStream struct {
+	Timestamp  time.Time `json:"ts"`
+	MsPlayed   int       `json:"ms_played"`
+	TrackName  string    `json:"master_metadata_track_name"`
+	ArtistName string    `json:"master_metadata_album_artist_name"`
+	AlbumName  string    `json:"master_metadata_album_album_name"`
+	TrackURI   string    `json:"spotify_track_uri"`
+}
+
+// ParseStreamingHistory reads an extended streaming history file and returns
+// its music plays. Podcast episodes and audiobooks have no track name and are
+// left out, as are tracks played for less than minStreamMs. The file is
+// decoded entry by entry since a single one can hold years of listening.
+func ParseStreamingHistory(r io.Reader) ([]types.Play, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, apperr.BadRequest("streaming history must be a JSON array")
+	}
+	plays := make([]types.Play, 0)
+	for dec.More() {
+		var stream exportedStream
+		if err := dec.Decode(&stream); err != nil {
+			return nil, apperr.Wrap(apperr.ErrBadRequest, errors.Wrap(err, "failed to decode streaming history entry"))
+		}
+		if stream.TrackName == "" || stream.MsPlayed < minStreamMs {
+			continue
+		}
+		plays = append(plays, playFromExport(stream))
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, apperr.Wrap(apperr.ErrBadRequest, errors.Wrap(err, "failed to decode streaming history"))
+	}
+	return plays, nil
+}
+
+func playFromExport(stream exportedStream) types.Play {
+	p := types.Play{
+		PlayedAt:  stream.Timestamp,
+		TrackID:   strings.TrimPrefix(stream.TrackURI, "spotify:track:"),
+		TrackName: stream.TrackName,
+		AlbumName: stream.AlbumName,
+		MsPlayed:  stream.MsPlayed,
+		Source:    types.PlaySourceExport,
+	}
+	if stream.ArtistName != "" {
+		p.ArtistNames = []string{stream.ArtistName}
+	}
+	return p
+}
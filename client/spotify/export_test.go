@@ -0,0 +1,36 @@
+package spotify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStreamingHistory(t *testing.T) {
+	export := `[
+		{"ts":"2023-05-01T10:00:00Z","ms_played":245000,"master_metadata_track_name":"Airbag","master_metadata_album_artist_name":"Radiohead","master_metadata_album_album_name":"OK Computer","spotify_track_uri":"spotify:track:6TOgDLbMwN1mCN7oDkkDtN"},
+		{"ts":"2023-05-01T10:04:00Z","ms_played":29999,"master_metadata_track_name":"Paranoid Android","master_metadata_album_artist_name":"Radiohead","master_metadata_album_album_name":"OK Computer","spotify_track_uri":"spotify:track:6LgJvl0Xdtc73RJ1mmpotq"},
+		{"ts":"2023-05-01T10:05:00Z","ms_played":30000,"master_metadata_track_name":"Subterranean Homesick Alien","master_metadata_album_artist_name":"Radiohead","master_metadata_album_album_name":"OK Computer","spotify_track_uri":"spotify:track:2tz0wxLeqUPL9Bd5cCmzWo"},
+		{"ts":"2023-05-01T11:00:00Z","ms_played":1800000,"master_metadata_track_name":null,"episode_name":"Episode 1","spotify_episode_uri":"spotify:episode:0Q86acNRm6V9GYx55SXKwf"}
+	]`
+
+	plays, err := ParseStreamingHistory(strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range plays {
+		names = append(names, p.TrackName)
+	}
+	// skips under 30s and podcast episodes are left out
+	if len(plays) != 2 || plays[0].TrackID != "6TOgDLbMwN1mCN7oDkkDtN" || plays[1].TrackName != "Subterranean Homesick Alien" {
+		t.Errorf("ParseStreamingHistory() = %q, want Airbag and Subterranean Homesick Alien", names)
+	}
+}
+
+func TestParseStreamingHistoryInvalid(t *testing.T) {
+	for _, export := range []string{`{}`, `[{"ts":"yesterday"}]`, `[{"ts":"2023-05-01T10:00:00Z"}`} {
+		if _, err := ParseStreamingHistory(strings.NewReader(export)); err == nil {
+			t.Errorf("ParseStreamingHistory(%s) error = nil, want a bad request", export)
+		}
+	}
+}
@@ -2,19 +2,20 @@ package spotify
 
 import (
 	"context"
-
-	"github.com/pkg/errors"
 )
 
-func (s *Spotify) Genres(ctx context.Context, token string) ([]string, error) {
+func (s *Spotify) Genres(ctx context.Context, sessionID string) ([]string, error) {
 	if g, err := s.repo.GetGenres(); err != nil && len(g) != 0 {
 		return g, nil
 	}
 
-	client := s.clientWithTrace(ctx, token)
+	client, err := s.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return []string{}, err
+	}
 	result, err := client.GetAvailableGenreSeeds(ctx)
 	if err != nil {
-		return []string{}, errors.Wrap(err, "fail to get spotify genre seeds")
+		return []string{}, upstreamError(err, "fail to get spotify genre seeds")
 	}
 	go s.repo.InsertGenres(result, nil)
 	return result, nil
@@ -0,0 +1,84 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+// RecentPlaysAfter returns the plays of an enrolled user after the given time,
+// oldest first. Spotify only keeps the last 50 plays so anything older than
+// that is lost if it was not collected in time.
+func (s *Spotify) RecentPlaysAfter(ctx context.Context, userID string, after time.Time) ([]types.Play, error) {
+	client, err := s.listenerClient(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	opts := &spotify.RecentlyPlayedOptions{Limit: maxPageSize}
+	if !after.IsZero() {
+		opts.AfterEpochMs = after.UnixMilli()
+	}
+	items, err := client.PlayerRecentlyPlayedOpt(ctx, opts)
+	if err != nil {
+		return nil, upstreamError(err, "fail to get spotify recently played tracks")
+	}
+
+	plays := make([]types.Play, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		plays = append(plays, playFromRecentlyPlayed(items[i]))
+	}
+	return plays, nil
+}
+
+func playFromRecentlyPlayed(item spotify.RecentlyPlayedItem) types.Play {
+	p := types.Play{
+		PlayedAt:   item.PlayedAt,
+		TrackID:    item.Track.ID.String(),
+		TrackName:  item.Track.Name,
+		DurationMs: item.Track.Duration,
+		Source:     types.PlaySourceSpotify,
+	}
+	for _, a := range item.Track.Artists {
+		p.ArtistIDs = append(p.ArtistIDs, a.ID.String())
+		p.ArtistNames = append(p.ArtistNames, a.Name)
+	}
+	return p
+}
+
+// ResolveTrackIDs fills in the Spotify ID of plays that only carry names, using
+// the best search match of the track and its artist. Plays without a match keep
+// an empty TrackID. Each distinct track is searched once, with the token of the
+// enrolled user.
+func (s *Spotify) ResolveTrackIDs(ctx context.Context, userID string, plays []types.Play) error {
+	client, err := s.listenerClient(ctx, userID)
+	if err != nil {
+		return err
+	}
+	resolved := make(map[string]string)
+	for i, p := range plays {
+		if p.TrackID != "" {
+			continue
+		}
+		query := fmt.Sprintf("track:%q", p.TrackName)
+		if len(p.ArtistNames) > 0 {
+			query += fmt.Sprintf(" artist:%q", p.ArtistNames[0])
+		}
+		id, ok := resolved[query]
+		if !ok {
+			res, err := client.Search(ctx, query, spotify.SearchTypeTrack, spotify.Limit(1))
+			if err != nil {
+				return upstreamError(err, "fail to search spotify track")
+			}
+			if res.Tracks != nil && len(res.Tracks.Tracks) > 0 {
+				id = res.Tracks.Tracks[0].ID.String()
+			}
+			resolved[query] = id
+		}
+		plays[i].TrackID = id
+	}
+	return nil
+}
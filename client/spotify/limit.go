@@ -0,0 +1,96 @@
+package spotify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+
+	"github.com/MinhPhu0304/spotify/trace"
+)
+
+// RateLimit configures how fast the Spotify API is called, by the whole
+// server and by every user. Zero values take the defaults.
+type RateLimit struct {
+	// Rate and Burst are the requests per second and the burst allowed in
+	// total.
+	Rate  float64
+	Burst int
+	// UserRate and UserBurst are the same for the calls of a single user.
+	UserRate  float64
+	UserBurst int
+	// MaxWait is how long a call may be queued before it fails with
+	// trace.ErrRateLimited.
+	MaxWait time.Duration
+}
+
+// DefaultRateLimit keeps the dashboard's fan out of a few users under
+// Spotify's rolling rate window.
+var DefaultRateLimit = RateLimit{Rate: 20, Burst: 40, UserRate: 5, UserBurst: 15, MaxWait: 5 * time.Second}
+
+func (l RateLimit) withDefaults() RateLimit {
+	if l.Rate <= 0 {
+		l.Rate = DefaultRateLimit.Rate
+	}
+	if l.Burst <= 0 {
+		l.Burst = DefaultRateLimit.Burst
+	}
+	if l.UserRate <= 0 {
+		l.UserRate = DefaultRateLimit.UserRate
+	}
+	if l.UserBurst <= 0 {
+		l.UserBurst = DefaultRateLimit.UserBurst
+	}
+	if l.MaxWait <= 0 {
+		l.MaxWait = DefaultRateLimit.MaxWait
+	}
+	return l
+}
+
+// userLimiterTTL is how long the limiter of an idle user is kept, by then its
+// bucket is full again.
+const userLimiterTTL = 10 * time.Minute
+
+// sessionLimitKey identifies a session among the limiters without keeping its
+// ID, which is a credential, in memory for as long as its limiter lives.
+func sessionLimitKey(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return "session-" + hex.EncodeToString(sum[:])
+}
+
+// backgroundLimitKey limits the calls made for a user outside of their
+// requests, such as history collection and imports, apart from the calls of
+// their dashboard so the latter is not starved by the former.
+func backgroundLimitKey(userID string) string {
+	return "background-" + userID
+}
+
+// limiters hands out the global limiter and the one of each user.
+type limiters struct {
+	config RateLimit
+	global *trace.Limiter
+	users  *cache.Cache
+}
+
+func newLimiters(config RateLimit) *limiters {
+	config = config.withDefaults()
+	return &limiters{
+		config: config,
+		global: trace.NewLimiter(config.Rate, config.Burst),
+		users:  cache.New(userLimiterTTL, userLimiterTTL),
+	}
+}
+
+// option limits the calls of the user, which may be a sessionLimitKey when the
+// session's user is not known yet.
+func (l *limiters) option(userID string) trace.Option {
+	user := trace.NewLimiter(l.config.UserRate, l.config.UserBurst)
+	if err := l.users.Add(userID, user, cache.DefaultExpiration); err != nil {
+		if existing, ok := l.users.Get(userID); ok {
+			user = existing.(*trace.Limiter)
+		}
+		l.users.Set(userID, user, cache.DefaultExpiration)
+	}
+	return trace.WithLimiter(l.config.MaxWait, l.global, user)
+}
@@ -0,0 +1,59 @@
+package spotify
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/zmb3/spotify/v2"
+
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+// maxPageSize is the most items Spotify returns in a single page.
+const maxPageSize = 50
+
+func pageSize(limit int) int {
+	if limit > maxPageSize {
+		return maxPageSize
+	}
+	return limit
+}
+
+// offsetPage slices items fetched from page.Offset. Next carries query, the
+// parameters the items depend on, so the following page lists the same ones.
+func offsetPage[T any](items []T, page types.PageRequest, total int, query url.Values) types.Page[T] {
+	if len(items) > page.Limit {
+		items = items[:page.Limit]
+	}
+	p := types.Page[T]{Items: items, Total: total}
+	if next := page.Offset + len(items); len(items) > 0 && next < total {
+		p.Next = fmt.Sprintf("limit=%d&offset=%d", page.Limit, next)
+		if len(query) > 0 {
+			p.Next += "&" + query.Encode()
+		}
+	}
+	return p
+}
+
+// timeRangeQuery is the query of the pages of a top list.
+func timeRangeQuery(timeRange spotify.Range) url.Values {
+	if timeRange == "" {
+		return nil
+	}
+	return url.Values{"time_range": {string(timeRange)}}
+}
+
+func cursorPage(items []spotify.RecentlyPlayedItem, page types.PageRequest) types.Page[spotify.RecentlyPlayedItem] {
+	if len(items) > page.Limit {
+		items = items[:page.Limit]
+	}
+	p := types.Page[spotify.RecentlyPlayedItem]{Items: items}
+	if len(items) == page.Limit {
+		oldest := items[len(items)-1].PlayedAt
+		p.Next = fmt.Sprintf("limit=%d&before=%d", page.Limit, oldest.UnixMilli())
+		if page.After != 0 {
+			p.Next += fmt.Sprintf("&after=%d", page.After)
+		}
+	}
+	return p
+}
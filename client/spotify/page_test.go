@@ -0,0 +1,69 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+
+	"github.com/MinhPhu0304/spotify/repository"
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+func TestTopArtistsNextPage(t *testing.T) {
+	const total = 5
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		ranges = append(ranges, q.Get("time_range"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		items := make([]map[string]string, 0)
+		for i := offset; i < offset+limit && i < total; i++ {
+			items = append(items, map[string]string{"id": fmt.Sprintf("artist-%d", i), "name": q.Get("time_range")})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "total": total, "limit": limit, "offset": offset})
+	}))
+	defer srv.Close()
+
+	repo := repository.CreateInMemoryRepo()
+	repo.InsertSession("session", &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)})
+	s := NewSpotifyClient("", repo, "", false, RateLimit{})
+	s.baseURL = srv.URL + "/"
+
+	ctx := context.Background()
+	page, err := s.TopArtists(ctx, "session", spotify.ShortTermRange, types.PageRequest{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; page.Next != ""; i++ {
+		if i == total {
+			t.Fatalf("still a next page after %d pages", i)
+		}
+		q, err := url.ParseQuery(page.Next)
+		if err != nil {
+			t.Fatalf("Next %q: %v", page.Next, err)
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		if page, err = s.TopArtists(ctx, "session", spotify.Range(q.Get("time_range")), types.PageRequest{Limit: limit, Offset: offset}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ranges) != 3 {
+		t.Fatalf("made %d requests, want 3 pages of 2 out of %d", len(ranges), total)
+	}
+	for i, r := range ranges {
+		if r != string(spotify.ShortTermRange) {
+			t.Errorf("request %d time_range = %q, want short_term", i, r)
+		}
+	}
+}
@@ -7,14 +7,17 @@ import (
 	"github.com/zmb3/spotify/v2"
 )
 
-func (c *Spotify) Recommendation(ctx context.Context, token string, trackID string) ([]spotify.SimpleTrack, error) {
-	client := c.clientWithTrace(ctx, token)
-	if client == nil {
-		return nil, errors.New("can not create spotify client")
+func (c *Spotify) Recommendation(ctx context.Context, sessionID string, trackID string) ([]spotify.SimpleTrack, error) {
+	client, err := c.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not create spotify client")
 	}
 	result, err := client.GetRecommendations(ctx,
 		spotify.Seeds{Tracks: []spotify.ID{spotify.ID(trackID)}},
 		nil,
 		spotify.Limit(100))
-	return result.Tracks, errors.Wrap(err, "fail to get spotify recommendation")
+	if err != nil {
+		return nil, upstreamError(err, "fail to get spotify recommendation")
+	}
+	return result.Tracks, nil
 }
@@ -2,11 +2,16 @@ package spotify
 
 import (
 	"context"
-	"fmt"
+	"crypto/rand"
+	"encoding/base64"
 	"net/http"
+	"net/url"
+	"time"
 
+	"github.com/MinhPhu0304/spotify/apperr"
 	"github.com/MinhPhu0304/spotify/repository"
 	"github.com/MinhPhu0304/spotify/trace"
+	"github.com/MinhPhu0304/spotify/types"
 	"github.com/pkg/errors"
 	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
@@ -15,11 +20,25 @@ import (
 
 type Spotify struct {
 	spotifyAuth  *spotifyauth.Authenticator
-	state        string
 	repo         repository.Repository
 	dashboardURI string
+	pkce         bool
+	limiters     *limiters
+	// baseURL overrides the Spotify Web API in tests.
+	baseURL string
 }
 
+var (
+	ErrSessionNotFound = apperr.Define(apperr.ErrUnauthorized, "spotify session not found")
+	ErrSessionExpired  = apperr.Define(apperr.ErrTokenExpired, "spotify session can no longer be refreshed")
+	ErrStateUnknown    = apperr.Define(apperr.ErrForbidden, "unknown login attempt")
+	ErrStateExpired    = apperr.Define(apperr.ErrGone, "login attempt expired")
+	ErrStateReplayed   = apperr.Define(apperr.ErrConflict, "login attempt already completed")
+)
+
+// stateTTL is how long a user has to complete the login after requesting the auth URL.
+const stateTTL = 10 * time.Minute
+
 var authScope = []string{
 	spotifyauth.ScopeUserTopRead,
 	spotifyauth.ScopeUserFollowRead,
@@ -27,74 +46,191 @@ var authScope = []string{
 	spotifyauth.ScopeUserReadRecentlyPlayed,
 }
 
-func NewSpotifyClient(redirectURI string, state string, repository repository.Repository, dashboardURI string) *Spotify {
-	auth := spotifyauth.New(
+// NewSpotifyClient creates the Spotify client. With pkce set the authorization
+// code flow is completed with a per-login code verifier instead of the client secret.
+// API calls are queued as configured by rateLimit.
+func NewSpotifyClient(redirectURI string, repository repository.Repository, dashboardURI string, pkce bool, rateLimit RateLimit) *Spotify {
+	opts := []spotifyauth.AuthenticatorOption{
 		spotifyauth.WithRedirectURL(redirectURI),
-		spotifyauth.WithScopes(authScope...))
+		spotifyauth.WithScopes(authScope...),
+	}
+	if pkce {
+		opts = append(opts, spotifyauth.WithClientSecret(""))
+	}
 
 	return &Spotify{
-		spotifyAuth:  auth,
-		state:        state,
+		spotifyAuth:  spotifyauth.New(opts...),
 		repo:         repository,
 		dashboardURI: dashboardURI,
+		pkce:         pkce,
+		limiters:     newLimiters(rateLimit),
 	}
 }
 
 func (s *Spotify) CompleteAuth(ctx context.Context, r *http.Request) (redirectURI string, err error) {
-	tok, err := s.spotifyAuth.Token(ctx, s.state, r)
+	st, err := s.consumeState(r.FormValue("state"))
+	if err != nil {
+		return "", err
+	}
+	var opts []oauth2.AuthCodeOption
+	if st.CodeVerifier != "" {
+		opts = append(opts, codeVerifierOption(st.CodeVerifier))
+	}
+	tok, err := s.spotifyAuth.Token(ctx, st.State, r, opts...)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get token")
 	}
-	if st := r.FormValue("state"); st != s.state {
-		return "", errors.New(fmt.Sprintf("State mismatch: %s != %s", st, s.state))
+
+	sessionID, err := randomToken()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create session")
+	}
+	if err := s.repo.InsertSession(sessionID, tok); err != nil {
+		return "", errors.Wrap(err, "failed to store session")
+	}
+	// Enrol the user in history collection, login still succeeds if this fails.
+	if userID, err := s.UserID(ctx, sessionID); err == nil {
+		s.repo.InsertListener(userID, tok)
 	}
-	dashboardURI := fmt.Sprintf("%s/callback?token=%s&expr=%s", s.dashboardURI, tok.AccessToken, tok.Expiry.UTC().String())
-	return dashboardURI, nil
+	expr := time.Now().Add(repository.SessionTTL)
+	// the session goes in the fragment, which browsers neither send to servers
+	// nor put in the Referer header
+	fragment := url.Values{"session": {sessionID}, "expr": {expr.UTC().String()}}
+	return s.dashboardURI + "/callback#" + fragment.Encode(), nil
 }
 
-func (s *Spotify) GetAuthURL() string {
-	return s.spotifyAuth.AuthURL(s.state)
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func (s *Spotify) TopArtists(ctx context.Context, token string, limit int) ([]spotify.FullArtist, error) {
-	client := s.clientWithTrace(ctx, token)
-	result, err := client.CurrentUsersTopArtists(ctx, spotify.Limit(limit))
+// GetAuthURL stores a login state the callback consumes, the callback may be
+// served by another instance so the repository has to be shared between them.
+func (s *Spotify) GetAuthURL() (string, error) {
+	state, err := randomToken()
 	if err != nil {
-		return []spotify.FullArtist{}, errors.Wrap(err, "fail to get spotify top artist")
+		return "", errors.Wrap(err, "failed to create oauth state")
+	}
+	st := &types.OAuthState{State: state, CreatedAt: time.Now()}
+	var opts []oauth2.AuthCodeOption
+	if s.pkce {
+		if st.CodeVerifier, err = codeVerifier(); err != nil {
+			return "", errors.Wrap(err, "failed to create pkce code verifier")
+		}
+		opts = codeChallengeOptions(st.CodeVerifier)
+	}
+	if err := s.repo.InsertOAuthState(st); err != nil {
+		return "", errors.Wrap(err, "failed to store oauth state")
 	}
-	return result.Artists, nil
+	return s.spotifyAuth.AuthURL(state, opts...), nil
 }
 
-func (s *Spotify) TopTracks(ctx context.Context, token string, opts ...spotify.RequestOption) ([]spotify.FullTrack, error) {
-	if t, err := s.repo.GetUserTopTracks(token); err != nil && len(t) != 0 {
-		return t, nil
+func (s *Spotify) consumeState(state string) (*types.OAuthState, error) {
+	if state == "" {
+		return nil, ErrStateUnknown
+	}
+	st, err := s.repo.ConsumeOAuthState(state)
+	if errors.Is(err, repository.ErrAlreadyConsumed) {
+		return nil, ErrStateReplayed
+	}
+	if err != nil {
+		return nil, ErrStateUnknown
 	}
+	if time.Since(st.CreatedAt) > stateTTL {
+		return nil, ErrStateExpired
+	}
+	return st, nil
+}
 
-	client := s.clientWithTrace(ctx, token)
-	result, err := client.CurrentUsersTopTracks(ctx, opts...)
+// TopArtists returns page.Limit of the user's top artists from page.Offset,
+// following Spotify's paging links when more than one page is asked for.
+func (s *Spotify) TopArtists(ctx context.Context, sessionID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullArtist], error) {
+	client, err := s.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return types.Page[spotify.FullArtist]{}, err
+	}
+	result, err := client.CurrentUsersTopArtists(ctx, spotify.Limit(pageSize(page.Limit)), spotify.Offset(page.Offset), spotify.Timerange(timeRange))
 	if err != nil {
-		return []spotify.FullTrack{}, errors.Wrap(err, "fail to get spotify top tracks")
+		return types.Page[spotify.FullArtist]{}, upstreamError(err, "fail to get spotify top artist")
+	}
+	artists := result.Artists
+	for len(artists) < page.Limit && result.Next != "" {
+		if err := client.NextPage(ctx, result); err != nil {
+			return types.Page[spotify.FullArtist]{}, upstreamError(err, "fail to get next page of spotify top artist")
+		}
+		artists = append(artists, result.Artists...)
 	}
-	defer func() {
-		s.repo.InsertUserTopTracks(result.Tracks, token)
-	}()
-	return result.Tracks, nil
+	return offsetPage(artists, page, result.Total, timeRangeQuery(timeRange)), nil
 }
 
-func (s *Spotify) RecentTracks(ctx context.Context, token string) ([]spotify.RecentlyPlayedItem, error) {
-	client := s.clientWithTrace(ctx, token)
-	result, err := client.PlayerRecentlyPlayedOpt(ctx, &spotify.RecentlyPlayedOptions{Limit: 50})
+// TopTracks returns page.Limit of the user's top tracks from page.Offset,
+// following Spotify's paging links when more than one page is asked for.
+func (s *Spotify) TopTracks(ctx context.Context, sessionID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullTrack], error) {
+	client, err := s.clientWithTrace(ctx, sessionID)
 	if err != nil {
-		return []spotify.RecentlyPlayedItem{}, errors.Wrap(err, "fail to get spotify top tracks")
+		return types.Page[spotify.FullTrack]{}, err
+	}
+	result, err := client.CurrentUsersTopTracks(ctx, spotify.Limit(pageSize(page.Limit)), spotify.Offset(page.Offset), spotify.Timerange(timeRange))
+	if err != nil {
+		return types.Page[spotify.FullTrack]{}, upstreamError(err, "fail to get spotify top tracks")
+	}
+	tracks := result.Tracks
+	for len(tracks) < page.Limit && result.Next != "" {
+		if err := client.NextPage(ctx, result); err != nil {
+			return types.Page[spotify.FullTrack]{}, upstreamError(err, "fail to get next page of spotify top tracks")
+		}
+		tracks = append(tracks, result.Tracks...)
+	}
+	return offsetPage(tracks, page, result.Total, timeRangeQuery(timeRange)), nil
+}
+
+// RecentTracks walks the recently played history backwards with the before
+// cursor until page.Limit plays are collected. With page.After set, only
+// plays after that time are returned.
+func (s *Spotify) RecentTracks(ctx context.Context, sessionID string, page types.PageRequest) (types.Page[spotify.RecentlyPlayedItem], error) {
+	client, err := s.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return types.Page[spotify.RecentlyPlayedItem]{}, err
 	}
 
-	return result, nil
+	// Spotify does not accept both cursors at once, After is then only applied as a filter.
+	opts := &spotify.RecentlyPlayedOptions{BeforeEpochMs: page.Before}
+	if page.Before == 0 {
+		opts.AfterEpochMs = page.After
+	}
+	items := make([]spotify.RecentlyPlayedItem, 0, page.Limit)
+	for len(items) < page.Limit {
+		opts.Limit = pageSize(page.Limit - len(items))
+		result, err := client.PlayerRecentlyPlayedOpt(ctx, opts)
+		if err != nil {
+			return types.Page[spotify.RecentlyPlayedItem]{}, upstreamError(err, "fail to get spotify recently played tracks")
+		}
+		for _, item := range result {
+			if page.After != 0 && item.PlayedAt.UnixMilli() <= page.After {
+				return cursorPage(items, page), nil
+			}
+			items = append(items, item)
+		}
+		if len(result) < opts.Limit {
+			break
+		}
+		opts.AfterEpochMs = 0
+		opts.BeforeEpochMs = result[len(result)-1].PlayedAt.UnixMilli()
+	}
+
+	return cursorPage(items, page), nil
 }
 
-func (s *Spotify) RelatedArtist(ctx context.Context, token string, artistID string) ([]spotify.FullArtist, error) {
-	client := s.clientWithTrace(ctx, token)
+func (s *Spotify) RelatedArtist(ctx context.Context, sessionID string, artistID string) ([]spotify.FullArtist, error) {
+	client, err := s.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
 	artist, err := client.GetRelatedArtists(ctx, spotify.ID(artistID))
-	return artist, err
+	return artist, upstreamError(err, "fail to get spotify related artists")
 }
 
 func allTrackID(tracks []spotify.FullTrack) []spotify.ID {
@@ -105,7 +241,56 @@ func allTrackID(tracks []spotify.FullTrack) []spotify.ID {
 	return trackIDs
 }
 
-func (s *Spotify) clientWithTrace(ctx context.Context, token string) *spotify.Client {
-	spc := s.spotifyAuth.Client(ctx, &oauth2.Token{AccessToken: token})
-	return spotify.New(trace.WrapWithTrace(spc))
+// clientWithTrace builds a spotify client for the session, refreshing and
+// persisting the session's token when it has expired.
+func (s *Spotify) clientWithTrace(ctx context.Context, sessionID string) (*spotify.Client, error) {
+	tok, err := s.repo.GetSession(sessionID)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	// calls are limited per user once the session's user is known
+	limitKey := sessionLimitKey(sessionID)
+	if userID, err := s.repo.GetSessionUser(sessionID); err == nil {
+		limitKey = userID
+	}
+	return s.tokenClient(ctx, tok, limitKey, func(fresh *oauth2.Token) {
+		s.repo.InsertSession(sessionID, fresh) // next request refreshes again if this fails
+	})
+}
+
+// listenerClient builds a spotify client from the token the user was enrolled
+// in history collection with, which is refreshed apart from their sessions.
+// It is meant for background work and limited as such.
+func (s *Spotify) listenerClient(ctx context.Context, userID string) (*spotify.Client, error) {
+	tok, err := s.repo.GetListenerToken(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get listener token")
+	}
+	return s.tokenClient(ctx, tok, backgroundLimitKey(userID), func(fresh *oauth2.Token) {
+		s.repo.InsertListener(userID, fresh)
+	})
+}
+
+// tokenClient builds a spotify client calling with tok, refreshed first when
+// it has expired and then handed to persist.
+func (s *Spotify) tokenClient(ctx context.Context, tok *oauth2.Token, limitKey string, persist func(*oauth2.Token)) (*spotify.Client, error) {
+	spc := s.spotifyAuth.Client(ctx, tok)
+	if t, ok := spc.Transport.(*oauth2.Transport); ok {
+		fresh, err := t.Source.Token()
+		if err != nil {
+			return nil, errors.Wrap(ErrSessionExpired, err.Error())
+		}
+		if fresh.AccessToken != tok.AccessToken {
+			persist(fresh)
+		}
+	}
+	spc = trace.WrapWithTrace(spc, s.limiters.option(limitKey))
+	spc.Transport = errorBodyTransport{spc.Transport}
+	if s.baseURL != "" {
+		return spotify.New(spc, spotify.WithBaseURL(s.baseURL)), nil
+	}
+	return spotify.New(spc), nil
 }
@@ -7,10 +7,16 @@ import (
 	"github.com/zmb3/spotify/v2"
 )
 
-func (c *Spotify) Track(ctx context.Context, token, trackId string) (spotify.FullTrack, error) {
-	client := c.clientWithTrace(ctx, token)
+func (c *Spotify) Track(ctx context.Context, sessionID, trackId string) (spotify.FullTrack, error) {
+	client, err := c.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return spotify.FullTrack{}, err
+	}
 	t, err := client.GetTrack(ctx, spotify.ID(trackId))
-	return *t, errors.Wrapf(err, "failed to get track detail")
+	if err != nil {
+		return spotify.FullTrack{}, upstreamError(err, "failed to get track detail")
+	}
+	return *t, nil
 }
 
 func tracksFeatures(ctx context.Context, client *spotify.Client, trackIDs []spotify.ID) (map[string]spotify.AudioFeatures, error) {
@@ -27,9 +33,12 @@ func tracksFeatures(ctx context.Context, client *spotify.Client, trackIDs []spot
 	return features, nil
 }
 
-func (c *Spotify) TrackFeatures(ctx context.Context, token string, trackIDs []spotify.ID) (map[string]spotify.AudioFeatures, error) {
-	sCl := c.clientWithTrace(ctx, token)
+func (c *Spotify) TrackFeatures(ctx context.Context, sessionID string, trackIDs []spotify.ID) (map[string]spotify.AudioFeatures, error) {
+	sCl, err := c.clientWithTrace(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
 
 	fs, err := tracksFeatures(ctx, sCl, trackIDs)
-	return fs, errors.Wrap(err, "failed to get track features")
+	return fs, upstreamError(err, "failed to get track features")
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/MinhPhu0304/spotify/client/spotify"
+	"github.com/MinhPhu0304/spotify/routes"
+)
+
+// importHistory adds Spotify streaming history files to a user's history:
+//
+//	spotify import-history -user <spotify user id> Streaming_History_Audio_*.json
+func importHistory(config routes.Config, args []string) error {
+	flags := flag.NewFlagSet("import-history", flag.ContinueOnError)
+	userID := flags.String("user", "", "Spotify user ID the history belongs to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userID == "" || flags.NArg() == 0 {
+		return errors.New("usage: import-history -user <spotify user id> FILE...")
+	}
+	if config.Repository == "" || config.Repository == "memory" {
+		return errors.New("importing history needs the bolt or redis repository")
+	}
+
+	srvc, err := routes.CreateService(config)
+	if err != nil {
+		return err
+	}
+	for _, name := range flags.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		plays, err := spotify.ParseStreamingHistory(f)
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+		result, err := srvc.ImportPlays(*userID, plays)
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+		log.Infof("%s: imported %d plays, skipped %d duplicates", name, result.Imported, result.Duplicates)
+	}
+	return nil
+}
+
+// collectHistory appends the latest plays of every enrolled user to their
+// history once, for schedulers outside of the server:
+//
+//	spotify collect-history
+func collectHistory(ctx context.Context, config routes.Config) error {
+	if config.Repository == "" || config.Repository == "memory" {
+		return errors.New("collecting history needs the bolt or redis repository")
+	}
+	srvc, err := routes.CreateService(config)
+	if err != nil {
+		return err
+	}
+	srvc.CollectHistory(ctx)
+	return nil
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/MinhPhu0304/spotify/client/spotify"
 	"github.com/MinhPhu0304/spotify/routes"
+	"github.com/MinhPhu0304/spotify/trace"
 	"github.com/akrylysov/algnhsa"
+	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/getsentry/sentry-go"
 	"github.com/joho/godotenv"
 
@@ -18,26 +24,97 @@ func main() {
 		log.Warn(err)
 	}
 
+	trace.DefaultRedactor = trace.NewRedactor(trace.RedactConfig{
+		Params:  listEnv("SENTRY_REDACT_PARAMS"),
+		Headers: []string{routes.SessionHeader},
+	})
+	if failures := intEnv("BREAKER_FAILURES"); failures > 0 {
+		trace.DefaultBreakerConfig.Failures = failures
+	}
+	if openFor := durationEnv("BREAKER_OPEN_FOR"); openFor > 0 {
+		trace.DefaultBreakerConfig.OpenFor = openFor
+	}
+	isAWS := os.Getenv("AWS_REGION")
+	tracer, sentryTracing, shutdownTracing := tracing(isAWS != "")
+	defer shutdownTracing()
+	trace.SetTracer(tracer)
+
 	sentrySyncTransport := sentry.NewHTTPSyncTransport()
 	if err := sentry.Init(sentry.ClientOptions{
 		Dsn:              os.Getenv("SENTRY_DSN"),
 		AttachStacktrace: true,
 		Environment:      "production",
 		Transport:        sentrySyncTransport,
-		EnableTracing:    true,
+		EnableTracing:    sentryTracing,
 		TracesSampleRate: 1.0,
+		// secrets of the upstream APIs and of our users must not reach sentry
+		BeforeSend:            trace.DefaultRedactor.Event,
+		BeforeSendTransaction: trace.DefaultRedactor.Event,
+		BeforeBreadcrumb:      trace.DefaultRedactor.Breadcrumb,
 	}); err != nil {
 		log.Errorf("sentry.Init: %s", err)
 		os.Exit(1)
 	}
 
-	isAWS := os.Getenv("AWS_REGION")
 	srvCfg := routes.Config{
-		SpotifyCallBackURI:  os.Getenv("CALLBACK_URI"),
-		SpotifyDashboardURI: os.Getenv("DASHBOARD_URI"),
-		LastFMToken:         os.Getenv("LASTFM_API_KEY"),
+		SpotifyCallBackURI:     os.Getenv("CALLBACK_URI"),
+		SpotifyDashboardURI:    os.Getenv("DASHBOARD_URI"),
+		LastFMToken:            os.Getenv("LASTFM_API_KEY"),
+		SpotifyPKCE:            os.Getenv("SPOTIFY_PKCE") == "true",
+		Repository:             os.Getenv("REPOSITORY"),
+		RepositoryPath:         os.Getenv("REPOSITORY_PATH"),
+		RedisURL:               os.Getenv("REDIS_URL"),
+		CacheStaleFor:          durationEnv("CACHE_STALE_FOR"),
+		HistoryCollectInterval: durationEnv("HISTORY_COLLECT_INTERVAL"),
+		MusicBrainzURL:         os.Getenv("MUSICBRAINZ_URL"),
+		SpotifyRateLimit: spotify.RateLimit{
+			Rate:     floatEnv("SPOTIFY_RATE_LIMIT"),
+			UserRate: floatEnv("SPOTIFY_USER_RATE_LIMIT"),
+			MaxWait:  durationEnv("SPOTIFY_RATE_LIMIT_WAIT"),
+		},
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-history" {
+		if err := importHistory(srvCfg, os.Args[2:]); err != nil {
+			log.Errorf("import-history: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "collect-history" {
+		if err := collectHistory(context.Background(), srvCfg); err != nil {
+			log.Errorf("collect-history: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Lambda instances share neither memory nor disk, with a local backend
+	// every cold start and every other instance would log everyone out, and
+	// a callback landing on another instance than its login would be refused.
+	if isAWS != "" && srvCfg.Repository != "redis" {
+		log.Errorf("REPOSITORY must be redis on Lambda, got %q", srvCfg.Repository)
+		os.Exit(1)
+	}
+
+	// Lambda freezes between requests so the collector goroutine would not
+	// run, the history is collected by a function of its own deployed with the
+	// same binary, LAMBDA_HANDLER=collect-history and an EventBridge schedule.
+	if isAWS != "" && os.Getenv("LAMBDA_HANDLER") == "collect-history" {
+		lambda.Start(func(ctx context.Context) error {
+			return collectHistory(ctx, srvCfg)
+		})
+		return
+	}
+	if isAWS != "" && srvCfg.HistoryCollectInterval > 0 {
+		log.Warn("HISTORY_COLLECT_INTERVAL is ignored on Lambda, schedule the collect-history handler instead")
+		srvCfg.HistoryCollectInterval = 0
+	}
+
+	server, err := routes.CreateServer(srvCfg)
+	if err != nil {
+		log.Errorf("routes.CreateServer: %s", err)
+		os.Exit(1)
 	}
-	server := routes.CreateServer(srvCfg)
 
 	if isAWS != "" {
 		//only if production:
@@ -52,3 +129,91 @@ func main() {
 	// Flush buffered events before the program terminates.
 	defer sentry.Flush(2 * time.Second)
 }
+
+// tracing sets up the exporters listed in TRACE_EXPORTERS, "sentry" (default)
+// and "otlp". Sentry reports errors whether or not it is one of them.
+func tracing(lambda bool) (tracer trace.Tracer, sentryTracing bool, shutdown func()) {
+	exporters := listEnv("TRACE_EXPORTERS")
+	if len(exporters) == 0 {
+		exporters = []string{"sentry"}
+	}
+	var tracers []trace.Tracer
+	var shutdowns []func(context.Context) error
+	for _, exporter := range exporters {
+		switch exporter {
+		case "sentry":
+			tracers = append(tracers, trace.Sentry())
+			sentryTracing = true
+		case "otlp":
+			t, shutdown, err := trace.OTLP(context.Background(), trace.OTLPConfig{ServiceName: "spotify", Sync: lambda})
+			if err != nil {
+				log.Errorf("trace.OTLP: %s", err)
+				continue
+			}
+			tracers = append(tracers, t)
+			shutdowns = append(shutdowns, shutdown)
+		default:
+			log.Warnf("unknown trace exporter %q", exporter)
+		}
+	}
+	return trace.Multi(tracers...), sentryTracing, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, shutdown := range shutdowns {
+			shutdown(ctx)
+		}
+	}
+}
+
+// durationEnv parses an optional duration such as "10m", zero when unset or invalid.
+func durationEnv(name string) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Warnf("invalid %s %q: %s", name, v, err)
+		return 0
+	}
+	return d
+}
+
+// intEnv parses an optional integer, zero when unset or invalid.
+func intEnv(name string) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warnf("invalid %s %q: %s", name, v, err)
+		return 0
+	}
+	return i
+}
+
+// floatEnv parses an optional number, zero when unset or invalid.
+func floatEnv(name string) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Warnf("invalid %s %q: %s", name, v, err)
+		return 0
+	}
+	return f
+}
+
+// listEnv parses an optional comma separated list.
+func listEnv(name string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
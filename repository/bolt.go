@@ -0,0 +1,510 @@
+package repository
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/oauth2"
+
+	"github.com/MinhPhu0304/spotify/types"
+	"github.com/MinhPhu0304/spotify/types/lastfm"
+)
+
+type boltRepository struct {
+	db       *bolt.DB
+	staleFor time.Duration
+}
+
+var (
+	cacheBucket      = []byte("cache")
+	metaBucket       = []byte("meta")
+	schemaVersionKey = []byte("schema_version")
+	// playsBucket holds a nested bucket per user keyed by play time.
+	playsBucket     = []byte("plays")
+	listenersBucket = []byte("listeners")
+	linksBucket     = []byte("lastfm-links")
+)
+
+// boltMigrations are applied in order. The meta bucket records how many of
+// them have already run against the database file.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(cacheBucket)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(playsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(listenersBucket)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(linksBucket)
+		return err
+	},
+}
+
+// boltRecord is what is stored under each key: the JSON encoded value and
+// the time it stops being served. FreshUntil is only set for records written
+// with stale-while-revalidate enabled.
+type boltRecord struct {
+	Value      json.RawMessage `json:"value"`
+	ExpiresAt  time.Time       `json:"expiresAt"`
+	FreshUntil time.Time       `json:"freshUntil,omitempty"`
+}
+
+// boltPurgeInterval is how often expired records are deleted. Records are only
+// deleted on read otherwise, and most keys are never read again once expired.
+const boltPurgeInterval = time.Hour
+
+// CreateBoltRepo opens (or creates) a bbolt database file at path and brings
+// its schema up to date. Expired records are purged on open and then every
+// boltPurgeInterval.
+func CreateBoltRepo(path string, opts ...Option) (Repository, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	r := &boltRepository{db: db, staleFor: newOptions(opts).staleFor}
+	if err := r.purgeExpired(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	go r.purgeEvery(boltPurgeInterval)
+	return r, nil
+}
+
+func migrate(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		version := 0
+		if v := meta.Get(schemaVersionKey); v != nil {
+			if version, err = strconv.Atoi(string(v)); err != nil {
+				return fmt.Errorf("invalid schema version %q: %w", v, err)
+			}
+		}
+		for i := version; i < len(boltMigrations); i++ {
+			if err := boltMigrations[i](tx); err != nil {
+				return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+			}
+		}
+		return meta.Put(schemaVersionKey, []byte(strconv.Itoa(len(boltMigrations))))
+	})
+}
+
+func (r *boltRepository) purgeExpired() error {
+	now := time.Now()
+	return r.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(cacheBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var rec boltRecord
+			if err := json.Unmarshal(v, &rec); err != nil || now.After(rec.ExpiresAt) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// purgeEvery purges expired records every interval until the database is
+// closed.
+func (r *boltRepository) purgeEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := r.purgeExpired(); errors.Is(err, bolt.ErrDatabaseNotOpen) {
+			return
+		}
+	}
+}
+
+func encodeRecord(value interface{}, ttl time.Duration, staleFor time.Duration) ([]byte, error) {
+	v, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	rec := boltRecord{Value: v, ExpiresAt: time.Now().Add(ttl + staleFor)}
+	if staleFor > 0 {
+		rec.FreshUntil = time.Now().Add(ttl)
+	}
+	return json.Marshal(rec)
+}
+
+// put stores value for exactly ttl.
+func (r *boltRepository) put(key string, value interface{}, ttl time.Duration) error {
+	return r.write(key, value, ttl, 0)
+}
+
+// putCached stores cached upstream data, which may be served stale.
+func (r *boltRepository) putCached(key string, value interface{}, ttl time.Duration) error {
+	return r.write(key, value, ttl, r.staleFor)
+}
+
+func (r *boltRepository) write(key string, value interface{}, ttl time.Duration, staleFor time.Duration) error {
+	rec, err := encodeRecord(value, ttl, staleFor)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheBucket).Put([]byte(key), rec)
+	})
+}
+
+func (r *boltRepository) delete(key string) {
+	r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheBucket).Delete([]byte(key))
+	})
+}
+
+// decodeRecord unwraps a stored record into value, reporting expired records
+// as not found and records past their fresh TTL as stale.
+func decodeRecord(raw []byte, value interface{}) error {
+	var rec boltRecord
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return ErrInvalidType
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		return ErrNotFound
+	}
+	if err := json.Unmarshal(rec.Value, value); err != nil {
+		return ErrInvalidType
+	}
+	if !rec.FreshUntil.IsZero() && time.Now().After(rec.FreshUntil) {
+		return ErrStale
+	}
+	return nil
+}
+
+func boltGet[T any](r *boltRepository, key string) (T, error) {
+	var value T
+	var raw []byte
+	r.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(cacheBucket).Get([]byte(key)); v != nil {
+			raw = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if raw == nil {
+		return value, ErrNotFound
+	}
+	err := decodeRecord(raw, &value)
+	if err != nil && !errors.Is(err, ErrStale) {
+		r.delete(key)
+	}
+	return value, err
+}
+
+func (r *boltRepository) GetUser(userID string) (*spotify.PrivateUser, error) {
+	return boltGet[*spotify.PrivateUser](r, userNamespace+userID)
+}
+
+func (r *boltRepository) InsertUser(userID string, user *spotify.PrivateUser, duration *time.Duration) error {
+	expr := userTTL
+	if duration != nil {
+		expr = *duration
+	}
+	return r.putCached(userNamespace+userID, user, expr)
+}
+
+func (r *boltRepository) GetArtistBio(artistID string, lang string) (*lastfm.LastFMBio, error) {
+	return boltGet[*lastfm.LastFMBio](r, ArtistBioKey(artistID, lang))
+}
+
+func (r *boltRepository) InsertArtistBio(bio *lastfm.LastFMBio, artistID string, lang string) error {
+	return r.putCached(ArtistBioKey(artistID, lang), bio, artistBioTTL)
+}
+
+func (r *boltRepository) GetUserTopTracks(userID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullTrack], error) {
+	return boltGet[types.Page[spotify.FullTrack]](r, UserTopTracksKey(userID, timeRange, page))
+}
+
+func (r *boltRepository) InsertUserTopTracks(topTracks types.Page[spotify.FullTrack], userID string, timeRange spotify.Range, page types.PageRequest) error {
+	return r.putCached(UserTopTracksKey(userID, timeRange, page), topTracks, userTopTrackTTL)
+}
+
+func (r *boltRepository) GetGenres() ([]string, error) {
+	return boltGet[[]string](r, spotifyGenres)
+}
+
+func (r *boltRepository) InsertGenres(genres []string, duration *time.Duration) error {
+	expr := genresTTL
+	if duration != nil {
+		expr = *duration
+	}
+	return r.putCached(spotifyGenres, genres, expr)
+}
+
+func (r *boltRepository) GetSpotifyArtist(artistID string) (*spotify.FullArtist, error) {
+	return boltGet[*spotify.FullArtist](r, spotifyArtistNamespace+artistID)
+}
+
+func (r *boltRepository) InsertSpotifyArtist(artist *spotify.FullArtist) error {
+	return r.putCached(spotifyArtistNamespace+artist.ID.String(), artist, spotifyArtistTTL)
+}
+
+func (r *boltRepository) GetArtistMBID(artistID string) (string, error) {
+	return boltGet[string](r, artistMBIDNamespace+artistID)
+}
+
+func (r *boltRepository) InsertArtistMBID(artistID string, mbid string) error {
+	return r.putCached(artistMBIDNamespace+artistID, mbid, artistMBIDTTL)
+}
+
+func (r *boltRepository) GetArtistInfo(artistID string, lang string) (*types.ArtistInfo, error) {
+	return boltGet[*types.ArtistInfo](r, ArtistInfoKey(artistID, lang))
+}
+
+func (r *boltRepository) InsertArtistInfo(artist *types.ArtistInfo, lang string) error {
+	return r.putCached(ArtistInfoKey(artist.Artirst.ID.String(), lang), artist, artistInfoTTL)
+}
+
+func (r *boltRepository) GetSpotifyFullTrack(trackID string) (*spotify.FullTrack, error) {
+	return boltGet[*spotify.FullTrack](r, SpotifyFullTrackKey(trackID))
+}
+
+func (r *boltRepository) InsertSpotifyFullTrack(fullTrack *spotify.FullTrack) error {
+	return r.putCached(SpotifyFullTrackKey(string(fullTrack.ID)), fullTrack, spotifyFullTrackTTL)
+}
+
+func (r *boltRepository) GetSong(trackID string) (*types.Song, error) {
+	return boltGet[*types.Song](r, SongKey(trackID))
+}
+
+func (r *boltRepository) InsertSong(song *types.Song) error {
+	return r.putCached(SongKey(string(song.Detail.ID)), song, songTTL)
+}
+
+func (r *boltRepository) GetUserTrackStats(userID string, trackID string) (*types.UserTrackStats, error) {
+	return boltGet[*types.UserTrackStats](r, UserTrackStatsKey(userID, trackID))
+}
+
+func (r *boltRepository) InsertUserTrackStats(userID string, trackID string, stats *types.UserTrackStats) error {
+	return r.putCached(UserTrackStatsKey(userID, trackID), stats, userTrackStatsTTL)
+}
+
+func (r *boltRepository) GetTopArtists(userID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullArtist], error) {
+	return boltGet[types.Page[spotify.FullArtist]](r, TopArtistsKey(userID, timeRange, page))
+}
+
+func (r *boltRepository) InsertTopArtist(userID string, artists types.Page[spotify.FullArtist], timeRange spotify.Range, page types.PageRequest) error {
+	return r.putCached(TopArtistsKey(userID, timeRange, page), artists, topArtistTTL)
+}
+
+func (r *boltRepository) GetSession(sessionID string) (*oauth2.Token, error) {
+	return boltGet[*oauth2.Token](r, sessionKey(sessionID))
+}
+
+func (r *boltRepository) InsertSession(sessionID string, token *oauth2.Token) error {
+	return r.put(sessionKey(sessionID), token, SessionTTL)
+}
+
+func (r *boltRepository) GetSessionUser(sessionID string) (string, error) {
+	return boltGet[string](r, sessionUserKey(sessionID))
+}
+
+func (r *boltRepository) InsertSessionUser(sessionID string, userID string) error {
+	return r.put(sessionUserKey(sessionID), userID, sessionUserTTL)
+}
+
+func (r *boltRepository) InsertOAuthState(state *types.OAuthState) error {
+	return r.put(oauthStateKey(state.State), state, oauthStateRetention)
+}
+
+// ConsumeOAuthState marks the state as used inside a single transaction so
+// concurrent callbacks cannot both consume it.
+func (r *boltRepository) ConsumeOAuthState(state string) (*types.OAuthState, error) {
+	key := []byte(oauthStateKey(state))
+	var st *types.OAuthState
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(cacheBucket)
+		raw := b.Get(key)
+		if raw == nil {
+			return ErrNotFound
+		}
+		if err := decodeRecord(raw, &st); err != nil {
+			return err
+		}
+		if st.Consumed {
+			return ErrAlreadyConsumed
+		}
+		consumed := *st
+		consumed.Consumed = true
+		rec, err := encodeRecord(&consumed, oauthStateRetention, 0)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// playKey orders plays chronologically within a user's bucket.
+func playKey(playedAt time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(playedAt.UnixMilli()))
+	return k
+}
+
+func (r *boltRepository) AppendPlays(userID string, plays []types.Play) (int, error) {
+	added := 0
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(playsBucket).CreateBucketIfNotExists([]byte(userID))
+		if err != nil {
+			return err
+		}
+		for _, p := range plays {
+			key := playKey(p.PlayedAt)
+			if b.Get(key) != nil {
+				continue
+			}
+			v, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, v); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	return added, err
+}
+
+func (r *boltRepository) GetPlays(userID string, query types.PlayQuery) ([]types.Play, error) {
+	plays := make([]types.Play, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(playsBucket).Bucket([]byte(userID))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		k, v := c.First()
+		if !query.From.IsZero() {
+			k, v = c.Seek(playKey(query.From))
+		}
+		for ; k != nil; k, v = c.Next() {
+			var p types.Play
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if !query.To.IsZero() && p.PlayedAt.After(query.To) {
+				break
+			}
+			if query.Matches(p) {
+				plays = append(plays, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newestPlays(plays, query.Limit), nil
+}
+
+func (r *boltRepository) GetLastPlayedAt(userID string) (time.Time, error) {
+	var last time.Time
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(playsBucket).Bucket([]byte(userID))
+		if b == nil {
+			return ErrNotFound
+		}
+		k, _ := b.Cursor().Last()
+		if k == nil {
+			return ErrNotFound
+		}
+		last = time.UnixMilli(int64(binary.BigEndian.Uint64(k)))
+		return nil
+	})
+	return last, err
+}
+
+func (r *boltRepository) InsertListener(userID string, token *oauth2.Token) error {
+	v, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(listenersBucket).Put([]byte(userID), v)
+	})
+}
+
+func (r *boltRepository) RemoveListener(userID string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(listenersBucket).Delete([]byte(userID))
+	})
+}
+
+func (r *boltRepository) GetListeners() ([]string, error) {
+	var listeners []string
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(listenersBucket).ForEach(func(k, _ []byte) error {
+			listeners = append(listeners, string(k))
+			return nil
+		})
+	})
+	return listeners, err
+}
+
+func (r *boltRepository) GetListenerToken(userID string) (*oauth2.Token, error) {
+	var token oauth2.Token
+	err := r.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(listenersBucket).Get([]byte(userID))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &token); err != nil {
+			return fmt.Errorf("failed to decode listener token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *boltRepository) InsertLastFMLink(userID string, link types.LastFMLink) error {
+	v, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(linksBucket).Put([]byte(userID), v)
+	})
+}
+
+func (r *boltRepository) GetLastFMLink(userID string) (types.LastFMLink, error) {
+	var link types.LastFMLink
+	err := r.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(linksBucket).Get([]byte(userID))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &link); err != nil {
+			return ErrInvalidType
+		}
+		return nil
+	})
+	return link, err
+}
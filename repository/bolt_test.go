@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+func newTestBoltRepo(t *testing.T, opts ...Option) *boltRepository {
+	t.Helper()
+	repo, err := CreateBoltRepo(filepath.Join(t.TempDir(), "cache.db"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := repo.(*boltRepository)
+	t.Cleanup(func() { r.db.Close() })
+	return r
+}
+
+// boltRecordOf returns the record stored under key, false when there is none.
+func boltRecordOf(t *testing.T, r *boltRepository, key string) (boltRecord, bool) {
+	t.Helper()
+	var rec boltRecord
+	var found bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(cacheBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &rec)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec, found
+}
+
+func mustEncodeRecord(t *testing.T, value interface{}, ttl time.Duration) []byte {
+	t.Helper()
+	rec, err := encodeRecord(value, ttl, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestBoltMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	// a database created before the history buckets were added
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucket(metaBucket)
+		if err != nil {
+			return err
+		}
+		if err := boltMigrations[0](tx); err != nil {
+			return err
+		}
+		if err := tx.Bucket(cacheBucket).Put([]byte("spotify-genres"), mustEncodeRecord(t, []string{"rock"}, time.Hour)); err != nil {
+			return err
+		}
+		return meta.Put(schemaVersionKey, []byte("1"))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// opening twice applies the migrations once
+	for i := 0; i < 2; i++ {
+		repo, err := CreateBoltRepo(path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		r := repo.(*boltRepository)
+		r.db.View(func(tx *bolt.Tx) error {
+			for _, b := range [][]byte{cacheBucket, playsBucket, listenersBucket, linksBucket} {
+				if tx.Bucket(b) == nil {
+					t.Errorf("open %d: no %s bucket", i, b)
+				}
+			}
+			if v := string(tx.Bucket(metaBucket).Get(schemaVersionKey)); v != strconv.Itoa(len(boltMigrations)) {
+				t.Errorf("open %d: schema version = %s, want %d", i, v, len(boltMigrations))
+			}
+			return nil
+		})
+		if genres, err := r.GetGenres(); err != nil || !reflect.DeepEqual(genres, []string{"rock"}) {
+			t.Errorf("open %d: GetGenres() = %v, %v, want the genres stored before the migrations", i, genres, err)
+		}
+		r.db.Close()
+	}
+}
+
+func TestBoltInvalidSchemaVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucket(metaBucket)
+		if err != nil {
+			return err
+		}
+		return meta.Put(schemaVersionKey, []byte("two"))
+	})
+	db.Close()
+
+	if _, err := CreateBoltRepo(path); err == nil {
+		t.Error("CreateBoltRepo() error = nil, want the invalid schema version reported")
+	}
+}
+
+func TestBoltRoundTrip(t *testing.T) {
+	testRoundTrip(t, newTestBoltRepo(t))
+}
+
+// TestBoltKeysMatchMemory checks that bolt stores entries under the same keys
+// and for the same time as the other backends.
+func TestBoltKeysMatchMemory(t *testing.T) {
+	const staleFor = time.Minute
+	for _, tt := range cacheKeyTests(staleFor) {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestBoltRepo(t, WithStaleWhileRevalidate(staleFor))
+			if err := tt.insert(r); err != nil {
+				t.Fatal(err)
+			}
+			rec, ok := boltRecordOf(t, r, tt.key)
+			if !ok {
+				t.Fatalf("bolt has no key %q", tt.key)
+			}
+			ttl := time.Until(rec.ExpiresAt)
+			if diff := tt.ttl + staleFor - ttl; diff < 0 || diff > time.Second {
+				t.Errorf("TTL(%q) = %v, want %v", tt.key, ttl, tt.ttl+staleFor)
+			}
+		})
+	}
+}
+
+func TestBoltExpiry(t *testing.T) {
+	r := newTestBoltRepo(t)
+	ttl := 10 * time.Millisecond
+	if err := r.InsertGenres([]string{"rock"}, &ttl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetGenres(); err != nil {
+		t.Fatalf("fresh entry error = %v", err)
+	}
+	time.Sleep(2 * ttl)
+	if _, err := r.GetGenres(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired entry error = %v, want ErrNotFound", err)
+	}
+	if _, ok := boltRecordOf(t, r, spotifyGenres); ok {
+		t.Error("expired entry kept after being read")
+	}
+}
+
+func TestBoltStaleWhileRevalidate(t *testing.T) {
+	r := newTestBoltRepo(t, WithStaleWhileRevalidate(time.Minute))
+	ttl := 10 * time.Millisecond
+	if err := r.InsertGenres([]string{"rock"}, &ttl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetGenres(); err != nil {
+		t.Fatalf("fresh entry error = %v", err)
+	}
+	time.Sleep(2 * ttl)
+	got, err := r.GetGenres()
+	if !errors.Is(err, ErrStale) || !reflect.DeepEqual(got, []string{"rock"}) {
+		t.Errorf("stale entry = %v, %v, want the genres with ErrStale", got, err)
+	}
+	if _, ok := boltRecordOf(t, r, spotifyGenres); !ok {
+		t.Error("stale entry deleted after being read")
+	}
+}
+
+func TestBoltPurgeExpired(t *testing.T) {
+	r := newTestBoltRepo(t)
+	ttl := 10 * time.Millisecond
+	if err := r.InsertGenres([]string{"rock"}, &ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.InsertSessionUser("session-1", "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	go r.purgeEvery(ttl)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := boltRecordOf(t, r, spotifyGenres); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired entry never purged")
+		}
+		time.Sleep(ttl)
+	}
+	if _, ok := boltRecordOf(t, r, sessionUserKey("session-1")); !ok {
+		t.Error("entry purged before it expired")
+	}
+}
+
+func TestBoltConsumeOAuthState(t *testing.T) {
+	r := newTestBoltRepo(t)
+	state := &types.OAuthState{State: "state-1", CreatedAt: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC), CodeVerifier: "verifier"}
+	if err := r.InsertOAuthState(state); err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := boltRecordOf(t, r, oauthStateKey(state.State))
+	if !ok {
+		t.Fatal("state not stored under its key")
+	}
+	if ttl := time.Until(rec.ExpiresAt); ttl > oauthStateRetention || ttl < oauthStateRetention-time.Second {
+		t.Errorf("TTL = %v, want %v", ttl, oauthStateRetention)
+	}
+	if _, ok := boltRecordOf(t, r, oauthStateNamespace+state.State); ok {
+		t.Error("state stored under its plain value")
+	}
+	testConsumeOAuthState(t, r, state)
+}
+
+func TestBoltConsumeOAuthStateConcurrently(t *testing.T) {
+	testConsumeOAuthStateConcurrently(t, newTestBoltRepo(t))
+}
+
+func TestBoltAppendPlays(t *testing.T) {
+	testAppendPlays(t, newTestBoltRepo(t))
+}
+
+func TestBoltListeners(t *testing.T) {
+	testListeners(t, newTestBoltRepo(t))
+}
@@ -1,109 +1,121 @@
 package repository
 
 import (
-	"errors"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
 	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
 
 	"github.com/MinhPhu0304/spotify/types"
 	"github.com/MinhPhu0304/spotify/types/lastfm"
 )
 
-type Repository interface {
-	GetUser(userToken string) (*spotify.PrivateUser, error)
-	InsertUser(userToken string, user *spotify.PrivateUser, duration *time.Duration) error
-	GetArtistBio(artistID string) (*lastfm.LastFMBio, error)
-	InsertArtistBio(bio *lastfm.LastFMBio, artistID string) error
-	GetUserTopTracks(userToken string) ([]spotify.FullTrack, error)
-	InsertUserTopTracks(topTracks []spotify.FullTrack, userToken string) error
-	GetGenres() ([]string, error)
-	InsertGenres(genres []string, duration *time.Duration) error
-	GetSpotifyArtist(artistID string) (*spotify.FullArtist, error)
-	InsertSpotifyArtist(artist *spotify.FullArtist) error
-	GetArtistInfo(artistID string) (*types.ArtistInfo, error)
-	InsertArtistInfo(artist *types.ArtistInfo) error
-	GetSpotifyFullTrack(trackID string) (*spotify.FullTrack, error)
-	InsertSpotifyFullTrack(fullTrack *spotify.FullTrack) error
-	GetSong(trackID string) (*types.Song, error)
-	InsertSong(song *types.Song) error
-	GetTopArtists(userToken string) ([]spotify.FullArtist, error)
-	InsertTopArtist(userToken string, artists []spotify.FullArtist) error
+type inMemoryRepository struct {
+	cache    *cache.Cache
+	stateMu  sync.Mutex
+	staleFor time.Duration
+
+	// play history is not a cache, it lives until the process exits.
+	historyMu sync.RWMutex
+	plays     map[string]map[int64]types.Play
+	listeners map[string]oauth2.Token
+	links     map[string]types.LastFMLink
 }
 
-type inMemoryRepository struct {
-	cache *cache.Cache
-}
-
-var (
-	ErrNotFound               = errors.New("record not found")
-	ErrInvalidType            = errors.New("invalid struct type store in cache")
-	userNamespace             = "user-"
-	artistNamespace           = "artist-"
-	artistBioNamespace        = "artirst-bio-"
-	topArtistNamespace        = "top-artist-"
-	userTopTrackNamespace     = "user-top-tracks-"
-	songNamespace             = "song-bio-"
-	spotifyFullTrackNamespace = "spotify-fulltrack-"
-	spotifyArtistNamespace    = "spotify-artist-"
-	spotifyGenres             = "spotify-genres"
-)
+// staleEntry wraps cached data when stale-while-revalidate is enabled so reads
+// can tell a fresh entry from one that is only kept around to be served stale.
+type staleEntry struct {
+	value      interface{}
+	freshUntil time.Time
+}
 
-func CreateInMemoryRepo() Repository {
+func CreateInMemoryRepo(opts ...Option) Repository {
+	o := newOptions(opts)
 	return &inMemoryRepository{
-		cache: cache.New(15*time.Minute, 15*time.Minute),
+		cache:     cache.New(defaultTTL+o.staleFor, defaultTTL),
+		staleFor:  o.staleFor,
+		plays:     make(map[string]map[int64]types.Play),
+		listeners: make(map[string]oauth2.Token),
+		links:     make(map[string]types.LastFMLink),
 	}
 }
 
-func (r *inMemoryRepository) GetUser(userToken string) (*spotify.PrivateUser, error) {
-	cacheKey := userNamespace + userToken
-	v, ok := r.cache.Get(cacheKey)
+// get returns the cached value with ErrStale as its error once it is past its
+// fresh TTL.
+func (r *inMemoryRepository) get(key string) (interface{}, bool, error) {
+	v, ok := r.cache.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if e, isEntry := v.(staleEntry); isEntry {
+		if time.Now().After(e.freshUntil) {
+			return e.value, true, ErrStale
+		}
+		return e.value, true, nil
+	}
+	return v, true, nil
+}
+
+// add caches value for ttl. With stale-while-revalidate the entry is kept for
+// an extra staleFor and overwritten on refresh instead of only being added.
+func (r *inMemoryRepository) add(key string, value interface{}, ttl time.Duration) error {
+	if r.staleFor == 0 {
+		return r.cache.Add(key, value, ttl)
+	}
+	r.cache.Set(key, staleEntry{value: value, freshUntil: time.Now().Add(ttl)}, ttl+r.staleFor)
+	return nil
+}
+
+func (r *inMemoryRepository) GetUser(userID string) (*spotify.PrivateUser, error) {
+	cacheKey := userNamespace + userID
+	v, ok, staleErr := r.get(cacheKey)
 	if !ok {
 		return nil, ErrNotFound
 	}
-	if _, valid := v.(spotify.User); !valid {
+	if _, valid := v.(*spotify.PrivateUser); !valid {
 		r.cache.Delete(cacheKey)
 		return nil, ErrInvalidType
 	}
-	return v.(*spotify.PrivateUser), nil
+	return v.(*spotify.PrivateUser), staleErr
 }
 
-func (r *inMemoryRepository) InsertUser(userToken string, user *spotify.PrivateUser, duration *time.Duration) error {
-	expr := cache.DefaultExpiration
+func (r *inMemoryRepository) InsertUser(userID string, user *spotify.PrivateUser, duration *time.Duration) error {
+	expr := userTTL
 	if duration != nil {
 		expr = *duration
 	}
 
-	return r.cache.Add(userNamespace+userToken, user, expr)
+	return r.add(userNamespace+userID, user, expr)
 }
 
-func (r *inMemoryRepository) InsertUserTopTracks(topTracks []spotify.FullTrack, userToken string) error {
-	cacheKey := userTopTrackNamespace + userToken
-	return r.cache.Add(cacheKey, topTracks, cache.DefaultExpiration)
+func (r *inMemoryRepository) InsertUserTopTracks(topTracks types.Page[spotify.FullTrack], userID string, timeRange spotify.Range, page types.PageRequest) error {
+	cacheKey := UserTopTracksKey(userID, timeRange, page)
+	return r.add(cacheKey, topTracks, userTopTrackTTL)
 }
 
-func (r *inMemoryRepository) GetUserTopTracks(userToken string) ([]spotify.FullTrack, error) {
-	cacheKey := userTopTrackNamespace + userToken
-	v, ok := r.cache.Get(cacheKey)
+func (r *inMemoryRepository) GetUserTopTracks(userID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullTrack], error) {
+	cacheKey := UserTopTracksKey(userID, timeRange, page)
+	v, ok, staleErr := r.get(cacheKey)
 	if !ok {
-		return nil, ErrNotFound
+		return types.Page[spotify.FullTrack]{}, ErrNotFound
 	}
-	if _, valid := v.([]spotify.FullTrack); !valid {
+	if _, valid := v.(types.Page[spotify.FullTrack]); !valid {
 		r.cache.Delete(cacheKey)
-		return nil, ErrInvalidType
+		return types.Page[spotify.FullTrack]{}, ErrInvalidType
 	}
-	return v.([]spotify.FullTrack), nil
+	return v.(types.Page[spotify.FullTrack]), staleErr
 }
 
-func (r *inMemoryRepository) InsertArtistBio(bio *lastfm.LastFMBio, artistID string) error {
-	cacheKey := artistBioNamespace + artistID
-	return r.cache.Add(cacheKey, bio, 0)
+func (r *inMemoryRepository) InsertArtistBio(bio *lastfm.LastFMBio, artistID string, lang string) error {
+	cacheKey := ArtistBioKey(artistID, lang)
+	return r.add(cacheKey, bio, artistBioTTL)
 }
 
-func (r *inMemoryRepository) GetArtistBio(artistID string) (*lastfm.LastFMBio, error) {
-	cacheKey := artistBioNamespace + artistID
-	v, ok := r.cache.Get(cacheKey)
+func (r *inMemoryRepository) GetArtistBio(artistID string, lang string) (*lastfm.LastFMBio, error) {
+	cacheKey := ArtistBioKey(artistID, lang)
+	v, ok, staleErr := r.get(cacheKey)
 	if !ok {
 		return nil, ErrNotFound
 	}
@@ -111,19 +123,19 @@ func (r *inMemoryRepository) GetArtistBio(artistID string) (*lastfm.LastFMBio, e
 		r.cache.Delete(cacheKey)
 		return nil, ErrInvalidType
 	}
-	return v.(*lastfm.LastFMBio), nil
+	return v.(*lastfm.LastFMBio), staleErr
 }
 
 func (r *inMemoryRepository) InsertGenres(genres []string, duration *time.Duration) error {
-	expr := cache.DefaultExpiration
+	expr := genresTTL
 	if duration != nil {
 		expr = *duration
 	}
-	return r.cache.Add(spotifyGenres, genres, expr)
+	return r.add(spotifyGenres, genres, expr)
 }
 
 func (r *inMemoryRepository) GetGenres() ([]string, error) {
-	v, ok := r.cache.Get(spotifyGenres)
+	v, ok, staleErr := r.get(spotifyGenres)
 	if !ok {
 		return nil, ErrNotFound
 	}
@@ -131,12 +143,12 @@ func (r *inMemoryRepository) GetGenres() ([]string, error) {
 		r.cache.Delete(spotifyGenres)
 		return nil, ErrInvalidType
 	}
-	return v.([]string), nil
+	return v.([]string), staleErr
 }
 
 func (r *inMemoryRepository) GetSpotifyArtist(artistID string) (*spotify.FullArtist, error) {
 	cacheKey := spotifyArtistNamespace + artistID
-	v, ok := r.cache.Get(cacheKey)
+	v, ok, staleErr := r.get(cacheKey)
 	if !ok {
 		return nil, ErrNotFound
 	}
@@ -144,18 +156,36 @@ func (r *inMemoryRepository) GetSpotifyArtist(artistID string) (*spotify.FullArt
 		r.cache.Delete(cacheKey)
 		return nil, ErrInvalidType
 	} else {
-		return v, nil
+		return v, staleErr
 	}
 }
 
 func (r *inMemoryRepository) InsertSpotifyArtist(artist *spotify.FullArtist) error {
 	cacheKey := spotifyArtistNamespace + artist.ID.String()
-	return r.cache.Add(cacheKey, artist, 2*time.Hour)
+	return r.add(cacheKey, artist, spotifyArtistTTL)
 }
 
-func (r *inMemoryRepository) GetArtistInfo(artistID string) (*types.ArtistInfo, error) {
-	cacheKey := artistNamespace + artistID
-	v, ok := r.cache.Get(cacheKey)
+func (r *inMemoryRepository) GetArtistMBID(artistID string) (string, error) {
+	cacheKey := artistMBIDNamespace + artistID
+	v, ok, staleErr := r.get(cacheKey)
+	if !ok {
+		return "", ErrNotFound
+	}
+	if v, valid := v.(string); !valid {
+		r.cache.Delete(cacheKey)
+		return "", ErrInvalidType
+	} else {
+		return v, staleErr
+	}
+}
+
+func (r *inMemoryRepository) InsertArtistMBID(artistID string, mbid string) error {
+	return r.add(artistMBIDNamespace+artistID, mbid, artistMBIDTTL)
+}
+
+func (r *inMemoryRepository) GetArtistInfo(artistID string, lang string) (*types.ArtistInfo, error) {
+	cacheKey := ArtistInfoKey(artistID, lang)
+	v, ok, staleErr := r.get(cacheKey)
 	if !ok {
 		return nil, ErrNotFound
 	}
@@ -163,18 +193,18 @@ func (r *inMemoryRepository) GetArtistInfo(artistID string) (*types.ArtistInfo,
 		r.cache.Delete(cacheKey)
 		return nil, ErrInvalidType
 	} else {
-		return v, nil
+		return v, staleErr
 	}
 }
 
-func (r *inMemoryRepository) InsertArtistInfo(artist *types.ArtistInfo) error {
-	cacheKey := artistNamespace + artist.Artirst.ID.String()
-	return r.cache.Add(cacheKey, artist, 5*time.Minute)
+func (r *inMemoryRepository) InsertArtistInfo(artist *types.ArtistInfo, lang string) error {
+	cacheKey := ArtistInfoKey(artist.Artirst.ID.String(), lang)
+	return r.add(cacheKey, artist, artistInfoTTL)
 }
 
 func (r *inMemoryRepository) GetSpotifyFullTrack(trackID string) (*spotify.FullTrack, error) {
-	cacheKey := spotifyFullTrackNamespace + trackID
-	v, ok := r.cache.Get(cacheKey)
+	cacheKey := SpotifyFullTrackKey(trackID)
+	v, ok, staleErr := r.get(cacheKey)
 	if !ok {
 		return nil, ErrNotFound
 	}
@@ -182,18 +212,18 @@ func (r *inMemoryRepository) GetSpotifyFullTrack(trackID string) (*spotify.FullT
 		r.cache.Delete(cacheKey)
 		return nil, ErrInvalidType
 	} else {
-		return v, nil
+		return v, staleErr
 	}
 }
 
 func (r *inMemoryRepository) InsertSpotifyFullTrack(fullTrack *spotify.FullTrack) error {
-	cacheKey := spotifyFullTrackNamespace + string(fullTrack.ID)
-	return r.cache.Add(cacheKey, fullTrack, 0)
+	cacheKey := SpotifyFullTrackKey(string(fullTrack.ID))
+	return r.add(cacheKey, fullTrack, spotifyFullTrackTTL)
 }
 
 func (r *inMemoryRepository) GetSong(trackID string) (*types.Song, error) {
-	cacheKey := songNamespace + trackID
-	v, ok := r.cache.Get(cacheKey)
+	cacheKey := SongKey(trackID)
+	v, ok, staleErr := r.get(cacheKey)
 	if !ok {
 		return nil, ErrNotFound
 	}
@@ -201,22 +231,59 @@ func (r *inMemoryRepository) GetSong(trackID string) (*types.Song, error) {
 		r.cache.Delete(cacheKey)
 		return nil, ErrInvalidType
 	} else {
-		return v, nil
+		return v, staleErr
 	}
 }
 
 func (r *inMemoryRepository) InsertSong(song *types.Song) error {
-	cacheKey := songNamespace + string(song.Detail.ID)
-	return r.cache.Add(cacheKey, song, 10*time.Minute)
+	cacheKey := SongKey(string(song.Detail.ID))
+	return r.add(cacheKey, song, songTTL)
+}
+
+func (r *inMemoryRepository) GetUserTrackStats(userID string, trackID string) (*types.UserTrackStats, error) {
+	cacheKey := UserTrackStatsKey(userID, trackID)
+	v, ok, staleErr := r.get(cacheKey)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if v, valid := v.(*types.UserTrackStats); !valid {
+		r.cache.Delete(cacheKey)
+		return nil, ErrInvalidType
+	} else {
+		return v, staleErr
+	}
+}
+
+func (r *inMemoryRepository) InsertUserTrackStats(userID string, trackID string, stats *types.UserTrackStats) error {
+	return r.add(UserTrackStatsKey(userID, trackID), stats, userTrackStatsTTL)
+}
+
+func (r *inMemoryRepository) GetTopArtists(userID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullArtist], error) {
+	cacheKey := TopArtistsKey(userID, timeRange, page)
+	v, ok, staleErr := r.get(cacheKey)
+	if !ok {
+		return types.Page[spotify.FullArtist]{}, ErrNotFound
+	}
+	if v, valid := v.(types.Page[spotify.FullArtist]); !valid {
+		r.cache.Delete(cacheKey)
+		return types.Page[spotify.FullArtist]{}, ErrInvalidType
+	} else {
+		return v, staleErr
+	}
+}
+
+func (r *inMemoryRepository) InsertTopArtist(userID string, artists types.Page[spotify.FullArtist], timeRange spotify.Range, page types.PageRequest) error {
+	cacheKey := TopArtistsKey(userID, timeRange, page)
+	return r.add(cacheKey, artists, topArtistTTL)
 }
 
-func (r *inMemoryRepository) GetTopArtists(userToken string) ([]spotify.FullArtist, error) {
-	cacheKey := topArtistNamespace + userToken
+func (r *inMemoryRepository) GetSession(sessionID string) (*oauth2.Token, error) {
+	cacheKey := sessionKey(sessionID)
 	v, ok := r.cache.Get(cacheKey)
 	if !ok {
 		return nil, ErrNotFound
 	}
-	if v, valid := v.([]spotify.FullArtist); !valid {
+	if v, valid := v.(*oauth2.Token); !valid {
 		r.cache.Delete(cacheKey)
 		return nil, ErrInvalidType
 	} else {
@@ -224,7 +291,156 @@ func (r *inMemoryRepository) GetTopArtists(userToken string) ([]spotify.FullArti
 	}
 }
 
-func (r *inMemoryRepository) InsertTopArtist(userToken string, artists []spotify.FullArtist) error {
-	cacheKey := topArtistNamespace + userToken
-	return r.cache.Add(cacheKey, artists, 10*time.Minute)
+// InsertSession overwrites any existing token so refreshed tokens replace the old ones.
+func (r *inMemoryRepository) InsertSession(sessionID string, token *oauth2.Token) error {
+	cacheKey := sessionKey(sessionID)
+	r.cache.Set(cacheKey, token, SessionTTL)
+	return nil
+}
+
+func (r *inMemoryRepository) GetSessionUser(sessionID string) (string, error) {
+	cacheKey := sessionUserKey(sessionID)
+	v, ok := r.cache.Get(cacheKey)
+	if !ok {
+		return "", ErrNotFound
+	}
+	if v, valid := v.(string); !valid {
+		r.cache.Delete(cacheKey)
+		return "", ErrInvalidType
+	} else {
+		return v, nil
+	}
+}
+
+func (r *inMemoryRepository) InsertSessionUser(sessionID string, userID string) error {
+	r.cache.Set(sessionUserKey(sessionID), userID, sessionUserTTL)
+	return nil
+}
+
+func (r *inMemoryRepository) InsertOAuthState(state *types.OAuthState) error {
+	cacheKey := oauthStateKey(state.State)
+	return r.cache.Add(cacheKey, state, oauthStateRetention)
+}
+
+// ConsumeOAuthState marks the state as used and returns it as it was before
+// consumption. A state can only be consumed once.
+func (r *inMemoryRepository) ConsumeOAuthState(state string) (*types.OAuthState, error) {
+	cacheKey := oauthStateKey(state)
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	v, ok := r.cache.Get(cacheKey)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	st, valid := v.(*types.OAuthState)
+	if !valid {
+		r.cache.Delete(cacheKey)
+		return nil, ErrInvalidType
+	}
+	if st.Consumed {
+		return nil, ErrAlreadyConsumed
+	}
+	consumed := *st
+	consumed.Consumed = true
+	r.cache.Set(cacheKey, &consumed, oauthStateRetention)
+	return st, nil
+}
+
+func (r *inMemoryRepository) AppendPlays(userID string, plays []types.Play) (int, error) {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	history, ok := r.plays[userID]
+	if !ok {
+		history = make(map[int64]types.Play)
+		r.plays[userID] = history
+	}
+	added := 0
+	for _, p := range plays {
+		key := p.PlayedAt.UnixMilli()
+		if _, exists := history[key]; exists {
+			continue
+		}
+		history[key] = p
+		added++
+	}
+	return added, nil
+}
+
+func (r *inMemoryRepository) GetPlays(userID string, query types.PlayQuery) ([]types.Play, error) {
+	r.historyMu.RLock()
+	defer r.historyMu.RUnlock()
+	plays := make([]types.Play, 0)
+	for _, p := range r.plays[userID] {
+		if query.Matches(p) {
+			plays = append(plays, p)
+		}
+	}
+	return newestPlays(plays, query.Limit), nil
+}
+
+func (r *inMemoryRepository) GetLastPlayedAt(userID string) (time.Time, error) {
+	r.historyMu.RLock()
+	defer r.historyMu.RUnlock()
+	var last time.Time
+	for _, p := range r.plays[userID] {
+		if p.PlayedAt.After(last) {
+			last = p.PlayedAt
+		}
+	}
+	if last.IsZero() {
+		return last, ErrNotFound
+	}
+	return last, nil
+}
+
+func (r *inMemoryRepository) InsertListener(userID string, token *oauth2.Token) error {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	r.listeners[userID] = *token
+	return nil
+}
+
+func (r *inMemoryRepository) RemoveListener(userID string) error {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	delete(r.listeners, userID)
+	return nil
+}
+
+func (r *inMemoryRepository) GetListeners() ([]string, error) {
+	r.historyMu.RLock()
+	defer r.historyMu.RUnlock()
+	listeners := make([]string, 0, len(r.listeners))
+	for userID := range r.listeners {
+		listeners = append(listeners, userID)
+	}
+	return listeners, nil
+}
+
+func (r *inMemoryRepository) GetListenerToken(userID string) (*oauth2.Token, error) {
+	r.historyMu.RLock()
+	defer r.historyMu.RUnlock()
+	token, ok := r.listeners[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+func (r *inMemoryRepository) InsertLastFMLink(userID string, link types.LastFMLink) error {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	r.links[userID] = link
+	return nil
+}
+
+func (r *inMemoryRepository) GetLastFMLink(userID string) (types.LastFMLink, error) {
+	r.historyMu.RLock()
+	defer r.historyMu.RUnlock()
+	link, ok := r.links[userID]
+	if !ok {
+		return types.LastFMLink{}, ErrNotFound
+	}
+	return link, nil
 }
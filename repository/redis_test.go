@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+func newTestRedisRepo(t *testing.T, opts ...Option) (*miniredis.Miniredis, Repository) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, NewRedisRepo(client, opts...)
+}
+
+func TestRedisRoundTrip(t *testing.T) {
+	_, repo := newTestRedisRepo(t)
+	testRoundTrip(t, repo)
+}
+
+// TestRedisKeysMatchMemory checks that both backends store entries under the
+// same keys and for the same time.
+func TestRedisKeysMatchMemory(t *testing.T) {
+	const staleFor = time.Minute
+	for _, tt := range cacheKeyTests(staleFor) {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, redisRepo := newTestRedisRepo(t, WithStaleWhileRevalidate(staleFor))
+			memRepo := CreateInMemoryRepo(WithStaleWhileRevalidate(staleFor)).(*inMemoryRepository)
+			if err := tt.insert(redisRepo); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.insert(memRepo); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := mr.TTL(tt.key); got != tt.ttl+staleFor {
+				t.Errorf("redis TTL(%q) = %v, want %v", tt.key, got, tt.ttl+staleFor)
+			}
+			item, ok := memRepo.cache.Items()[tt.key]
+			if !ok {
+				t.Fatalf("memory has no key %q", tt.key)
+			}
+			memTTL := time.Until(time.Unix(0, item.Expiration))
+			if diff := tt.ttl + staleFor - memTTL; diff < 0 || diff > time.Second {
+				t.Errorf("memory TTL(%q) = %v, want %v", tt.key, memTTL, tt.ttl+staleFor)
+			}
+		})
+	}
+}
+
+func TestRedisStaleWhileRevalidate(t *testing.T) {
+	mr, repo := newTestRedisRepo(t, WithStaleWhileRevalidate(time.Minute))
+	track := testTrack("6LgJvl0Xdtc73RJ1mmpotq")
+	if err := repo.InsertSpotifyFullTrack(&track); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetSpotifyFullTrack(string(track.ID)); err != nil {
+		t.Fatalf("fresh entry error = %v", err)
+	}
+	mr.FastForward(spotifyFullTrackTTL + time.Second)
+	got, err := repo.GetSpotifyFullTrack(string(track.ID))
+	if !errors.Is(err, ErrStale) || got == nil || got.ID != track.ID {
+		t.Errorf("stale entry = %v, %v, want the track with ErrStale", got, err)
+	}
+}
+
+func TestRedisConsumeOAuthState(t *testing.T) {
+	mr, repo := newTestRedisRepo(t)
+	state := &types.OAuthState{State: "state-1", CreatedAt: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC), CodeVerifier: "verifier"}
+	if err := repo.InsertOAuthState(state); err != nil {
+		t.Fatal(err)
+	}
+	if got := mr.TTL(oauthStateKey(state.State)); got != oauthStateRetention {
+		t.Errorf("TTL = %v, want %v", got, oauthStateRetention)
+	}
+	if mr.Exists(oauthStateNamespace + state.State) {
+		t.Error("state stored under its plain value")
+	}
+
+	testConsumeOAuthState(t, repo, state)
+}
+
+func TestRedisConsumeOAuthStateConcurrently(t *testing.T) {
+	_, repo := newTestRedisRepo(t)
+	testConsumeOAuthStateConcurrently(t, repo)
+}
+
+func TestRedisAppendPlays(t *testing.T) {
+	_, repo := newTestRedisRepo(t)
+	testAppendPlays(t, repo)
+}
+
+func TestRedisListeners(t *testing.T) {
+	_, repo := newTestRedisRepo(t)
+	testListeners(t, repo)
+}
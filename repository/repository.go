@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+
+	"github.com/MinhPhu0304/spotify/types"
+	"github.com/MinhPhu0304/spotify/types/lastfm"
+)
+
+type Repository interface {
+	GetUser(userID string) (*spotify.PrivateUser, error)
+	InsertUser(userID string, user *spotify.PrivateUser, duration *time.Duration) error
+	GetArtistBio(artistID string, lang string) (*lastfm.LastFMBio, error)
+	InsertArtistBio(bio *lastfm.LastFMBio, artistID string, lang string) error
+	GetUserTopTracks(userID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullTrack], error)
+	InsertUserTopTracks(topTracks types.Page[spotify.FullTrack], userID string, timeRange spotify.Range, page types.PageRequest) error
+	GetGenres() ([]string, error)
+	InsertGenres(genres []string, duration *time.Duration) error
+	GetSpotifyArtist(artistID string) (*spotify.FullArtist, error)
+	InsertSpotifyArtist(artist *spotify.FullArtist) error
+	GetArtistMBID(artistID string) (string, error)
+	InsertArtistMBID(artistID string, mbid string) error
+	GetArtistInfo(artistID string, lang string) (*types.ArtistInfo, error)
+	InsertArtistInfo(artist *types.ArtistInfo, lang string) error
+	GetSpotifyFullTrack(trackID string) (*spotify.FullTrack, error)
+	InsertSpotifyFullTrack(fullTrack *spotify.FullTrack) error
+	GetSong(trackID string) (*types.Song, error)
+	InsertSong(song *types.Song) error
+	GetUserTrackStats(userID string, trackID string) (*types.UserTrackStats, error)
+	InsertUserTrackStats(userID string, trackID string, stats *types.UserTrackStats) error
+	GetTopArtists(userID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullArtist], error)
+	InsertTopArtist(userID string, artists types.Page[spotify.FullArtist], timeRange spotify.Range, page types.PageRequest) error
+	GetSession(sessionID string) (*oauth2.Token, error)
+	InsertSession(sessionID string, token *oauth2.Token) error
+	GetSessionUser(sessionID string) (string, error)
+	InsertSessionUser(sessionID string, userID string) error
+	InsertOAuthState(state *types.OAuthState) error
+	ConsumeOAuthState(state string) (*types.OAuthState, error)
+	AppendPlays(userID string, plays []types.Play) (int, error)
+	GetPlays(userID string, query types.PlayQuery) ([]types.Play, error)
+	GetLastPlayedAt(userID string) (time.Time, error)
+	// InsertListener enrols the user in history collection with a token of
+	// their own, apart from their sessions so none of them is stored in clear.
+	InsertListener(userID string, token *oauth2.Token) error
+	RemoveListener(userID string) error
+	GetListeners() ([]string, error)
+	GetListenerToken(userID string) (*oauth2.Token, error)
+	InsertLastFMLink(userID string, link types.LastFMLink) error
+	GetLastFMLink(userID string) (types.LastFMLink, error)
+}
+
+var (
+	ErrNotFound        = errors.New("record not found")
+	ErrInvalidType     = errors.New("invalid struct type store in cache")
+	ErrAlreadyConsumed = errors.New("record already consumed")
+	// ErrStale is returned together with the cached value once it is past its
+	// fresh TTL but still inside the stale window.
+	ErrStale                  = errors.New("record is stale")
+	userNamespace             = "user-"
+	artistNamespace           = "artist-"
+	artistBioNamespace        = "artirst-bio-"
+	artistMBIDNamespace       = "artist-mbid-"
+	topArtistNamespace        = "top-artist-"
+	userTopTrackNamespace     = "user-top-tracks-"
+	songNamespace             = "song-bio-"
+	userTrackStatsNamespace   = "user-track-stats-"
+	spotifyFullTrackNamespace = "spotify-fulltrack-"
+	spotifyArtistNamespace    = "spotify-artist-"
+	spotifyGenres             = "spotify-genres"
+	sessionNamespace          = "session-"
+	oauthStateNamespace       = "oauth-state-"
+	sessionUserNamespace      = "session-user-"
+	playHistoryNamespace      = "play-history-"
+	playIndexNamespace        = "play-history-index-"
+	historyListeners          = "history-listeners"
+	lastFMLinks               = "lastfm-links"
+)
+
+// SessionTTL is how long a login session is kept after its last token refresh.
+const SessionTTL = 30 * 24 * time.Hour
+
+// Expiration of each namespace, shared by every Repository implementation.
+const (
+	defaultTTL          = 15 * time.Minute
+	userTTL             = defaultTTL
+	genresTTL           = defaultTTL
+	userTopTrackTTL     = defaultTTL
+	artistBioTTL        = defaultTTL
+	spotifyFullTrackTTL = defaultTTL
+	spotifyArtistTTL    = 2 * time.Hour
+	// artistMBIDTTL is long as an artist's MusicBrainz ID does not change.
+	artistMBIDTTL     = 7 * 24 * time.Hour
+	artistInfoTTL     = 5 * time.Minute
+	songTTL           = 10 * time.Minute
+	userTrackStatsTTL = 10 * time.Minute
+	topArtistTTL      = 10 * time.Minute
+	// sessionUserTTL is short so a session is re-resolved to its Spotify user regularly.
+	sessionUserTTL = 5 * time.Minute
+	// oauthStateRetention keeps consumed and expired states around long enough to
+	// tell a replay or a late callback apart from a forged state.
+	oauthStateRetention = time.Hour
+)
+
+type options struct {
+	staleFor time.Duration
+}
+
+type Option func(*options)
+
+// WithStaleWhileRevalidate keeps cached data for staleFor past its fresh TTL.
+// Reads in that window return the value together with ErrStale so the caller
+// can serve it and refresh it in the background. Sessions and OAuth states are
+// never served stale.
+func WithStaleWhileRevalidate(staleFor time.Duration) Option {
+	return func(o *options) {
+		o.staleFor = staleFor
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// hashKey is used for every key derived from a secret so that sessions and
+// states never appear in the store in plain text.
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// The exported keys identify an entry across every Repository implementation
+// and double as keys for deduplicating in-flight fetches of that entry.
+
+// ArtistInfoKey and ArtistBioKey take the language the bio was asked in.
+func ArtistInfoKey(artistID string, lang string) string {
+	return fmt.Sprintf("%s%s-%s", artistNamespace, artistID, lang)
+}
+
+func ArtistBioKey(artistID string, lang string) string {
+	return fmt.Sprintf("%s%s-%s", artistBioNamespace, artistID, lang)
+}
+
+func TopArtistsKey(userID string, timeRange spotify.Range, page types.PageRequest) string {
+	return fmt.Sprintf("%s%s-%s-%d-%d", topArtistNamespace, userID, timeRange, page.Limit, page.Offset)
+}
+
+func UserTopTracksKey(userID string, timeRange spotify.Range, page types.PageRequest) string {
+	return fmt.Sprintf("%s%s-%s-%d-%d", userTopTrackNamespace, userID, timeRange, page.Limit, page.Offset)
+}
+
+func SongKey(trackID string) string {
+	return songNamespace + trackID
+}
+
+func UserTrackStatsKey(userID string, trackID string) string {
+	return fmt.Sprintf("%s%s-%s", userTrackStatsNamespace, userID, trackID)
+}
+
+func SpotifyFullTrackKey(trackID string) string {
+	return spotifyFullTrackNamespace + trackID
+}
+
+func sessionKey(sessionID string) string {
+	return sessionNamespace + hashKey(sessionID)
+}
+
+func sessionUserKey(sessionID string) string {
+	return sessionUserNamespace + hashKey(sessionID)
+}
+
+func oauthStateKey(state string) string {
+	return oauthStateNamespace + hashKey(state)
+}
+
+// newestPlays sorts plays newest first and applies the query limit.
+func newestPlays(plays []types.Play, limit int) []types.Play {
+	sort.Slice(plays, func(i, j int) bool {
+		return plays[i].PlayedAt.After(plays[j].PlayedAt)
+	})
+	if limit > 0 && len(plays) > limit {
+		plays = plays[:limit]
+	}
+	return plays
+}
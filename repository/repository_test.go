@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+func testTrack(id string) spotify.FullTrack {
+	return spotify.FullTrack{
+		SimpleTrack: spotify.SimpleTrack{
+			ID:       spotify.ID(id),
+			Name:     "Paranoid Android",
+			Duration: 387000,
+			Artists:  []spotify.SimpleArtist{{ID: "4Z8W4fKeB5YxbusRsdQVPb", Name: "Radiohead"}},
+		},
+		Album:       spotify.SimpleAlbum{Name: "OK Computer", ReleaseDate: "1997-05-21"},
+		ExternalIDs: map[string]string{"isrc": "GBAYE9700106"},
+		Popularity:  71,
+	}
+}
+
+// cacheKeyTest is an entry written to the cache, under key for ttl.
+type cacheKeyTest struct {
+	name   string
+	key    string
+	ttl    time.Duration
+	insert func(Repository) error
+}
+
+// cacheKeyTests lists entries of each kind, every backend must store them
+// under the same keys and for the same time.
+func cacheKeyTests(staleFor time.Duration) []cacheKeyTest {
+	const userID = "user-1"
+	track := testTrack("6LgJvl0Xdtc73RJ1mmpotq")
+	info := &types.ArtistInfo{Artirst: spotify.FullArtist{SimpleArtist: spotify.SimpleArtist{ID: "4Z8W4fKeB5YxbusRsdQVPb"}}}
+	page := types.PageRequest{Limit: 20, Offset: 20}
+
+	return []cacheKeyTest{
+		{"song", SongKey(string(track.ID)), songTTL, func(r Repository) error {
+			return r.InsertSong(&types.Song{Detail: track})
+		}},
+		{"artist info", ArtistInfoKey(info.Artirst.ID.String(), "ja"), artistInfoTTL, func(r Repository) error {
+			return r.InsertArtistInfo(info, "ja")
+		}},
+		{"full track", SpotifyFullTrackKey(string(track.ID)), spotifyFullTrackTTL, func(r Repository) error {
+			return r.InsertSpotifyFullTrack(&track)
+		}},
+		{"top tracks", UserTopTracksKey(userID, spotify.LongTermRange, page), userTopTrackTTL, func(r Repository) error {
+			return r.InsertUserTopTracks(types.Page[spotify.FullTrack]{}, userID, spotify.LongTermRange, page)
+		}},
+		{"top artists", TopArtistsKey(userID, spotify.LongTermRange, page), topArtistTTL, func(r Repository) error {
+			return r.InsertTopArtist(userID, types.Page[spotify.FullArtist]{}, spotify.LongTermRange, page)
+		}},
+		{"artist mbid", artistMBIDNamespace + "artist-1", artistMBIDTTL, func(r Repository) error {
+			return r.InsertArtistMBID("artist-1", "a74b1b7f-71a5-4011-9441-d0b5e4122711")
+		}},
+		{"session user", sessionUserKey("session-1"), sessionUserTTL - staleFor, func(r Repository) error {
+			return r.InsertSessionUser("session-1", userID)
+		}},
+	}
+}
+
+// testRoundTrip checks that cached entries come back as they were stored.
+func testRoundTrip(t *testing.T, repo Repository) {
+	const userID = "user-1"
+	track := testTrack("6LgJvl0Xdtc73RJ1mmpotq")
+	song := &types.Song{
+		Detail:          track,
+		Features:        spotify.AudioFeatures{ID: track.ID, Energy: 0.5, Tempo: 80.3},
+		Recommendations: []spotify.SimpleTrack{track.SimpleTrack},
+		LastFM:          &types.TrackStats{Listeners: 10, Playcount: 20, Tags: []string{"rock"}},
+	}
+	info := &types.ArtistInfo{
+		Artirst:    spotify.FullArtist{SimpleArtist: spotify.SimpleArtist{ID: "4Z8W4fKeB5YxbusRsdQVPb", Name: "Radiohead"}, Genres: []string{"rock"}},
+		TopTracks:  []spotify.FullTrack{track},
+		Bio:        []string{"Radiohead are an English rock band."},
+		BioLinks:   []types.BioLink{{Name: "Thom Yorke", URL: "https://www.last.fm/music/Thom+Yorke"}},
+		BioLang:    "en",
+		BioSummary: "Radiohead are an English rock band.",
+	}
+	page := types.PageRequest{Limit: 20}
+	topTracks := types.Page[spotify.FullTrack]{Items: []spotify.FullTrack{track}, Next: "next", Total: 40}
+
+	if err := repo.InsertSong(song); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.InsertArtistInfo(info, "en"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.InsertSpotifyFullTrack(&track); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.InsertUserTopTracks(topTracks, userID, spotify.ShortTermRange, page); err != nil {
+		t.Fatal(err)
+	}
+
+	gotSong, err := repo.GetSong(string(track.ID))
+	if err != nil || !reflect.DeepEqual(gotSong, song) {
+		t.Errorf("GetSong() = %+v, %v, want %+v", gotSong, err, song)
+	}
+	gotInfo, err := repo.GetArtistInfo(info.Artirst.ID.String(), "en")
+	if err != nil || !reflect.DeepEqual(gotInfo, info) {
+		t.Errorf("GetArtistInfo() = %+v, %v, want %+v", gotInfo, err, info)
+	}
+	gotTrack, err := repo.GetSpotifyFullTrack(string(track.ID))
+	if err != nil || !reflect.DeepEqual(*gotTrack, track) {
+		t.Errorf("GetSpotifyFullTrack() = %+v, %v, want %+v", gotTrack, err, track)
+	}
+	gotPage, err := repo.GetUserTopTracks(userID, spotify.ShortTermRange, page)
+	if err != nil || !reflect.DeepEqual(gotPage, topTracks) {
+		t.Errorf("GetUserTopTracks() = %+v, %v, want %+v", gotPage, err, topTracks)
+	}
+	if _, err := repo.GetSong("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetSong(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+// testConsumeOAuthState consumes state, stored beforehand, then replays it.
+func testConsumeOAuthState(t *testing.T, repo Repository, state *types.OAuthState) {
+	got, err := repo.ConsumeOAuthState(state.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CodeVerifier != state.CodeVerifier || !got.CreatedAt.Equal(state.CreatedAt) {
+		t.Errorf("ConsumeOAuthState() = %+v, want %+v", got, state)
+	}
+	if _, err := repo.ConsumeOAuthState(state.State); !errors.Is(err, ErrAlreadyConsumed) {
+		t.Errorf("replayed ConsumeOAuthState() error = %v, want ErrAlreadyConsumed", err)
+	}
+	if _, err := repo.ConsumeOAuthState("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown ConsumeOAuthState() error = %v, want ErrNotFound", err)
+	}
+}
+
+// testConsumeOAuthStateConcurrently checks that a state is consumed once
+// however many callbacks race for it.
+func testConsumeOAuthStateConcurrently(t *testing.T, repo Repository) {
+	if err := repo.InsertOAuthState(&types.OAuthState{State: "state-1", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	const callbacks = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callbacks)
+	for i := 0; i < callbacks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.ConsumeOAuthState("state-1")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	consumed := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			consumed++
+		case !errors.Is(err, ErrAlreadyConsumed):
+			t.Errorf("ConsumeOAuthState() error = %v, want ErrAlreadyConsumed", err)
+		}
+	}
+	if consumed != 1 {
+		t.Errorf("state consumed %d times, want once", consumed)
+	}
+}
+
+// testAppendPlays checks that plays are stored once per play time.
+func testAppendPlays(t *testing.T, repo Repository) {
+	at := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	plays := []types.Play{
+		{PlayedAt: at, TrackID: "track-1", TrackName: "Airbag", ArtistNames: []string{"Radiohead"}, Source: types.PlaySourceSpotify},
+		{PlayedAt: at.Add(5 * time.Minute), TrackID: "track-2", TrackName: "Paranoid Android", ArtistNames: []string{"Radiohead"}, Source: types.PlaySourceSpotify},
+	}
+
+	added, err := repo.AppendPlays("user-1", plays)
+	if err != nil || added != 2 {
+		t.Fatalf("AppendPlays() = %d, %v, want 2", added, err)
+	}
+	// the second play again along with a new one
+	more := []types.Play{plays[1], {PlayedAt: at.Add(10 * time.Minute), TrackName: "Subterranean Homesick Alien", ArtistNames: []string{"Radiohead"}, Source: types.PlaySourceExport}}
+	added, err = repo.AppendPlays("user-1", more)
+	if err != nil || added != 1 {
+		t.Fatalf("AppendPlays() again = %d, %v, want 1", added, err)
+	}
+
+	got, err := repo.GetPlays("user-1", types.PlayQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("GetPlays() returned %d plays, want 3", len(got))
+	}
+	last, err := repo.GetLastPlayedAt("user-1")
+	if err != nil || !last.Equal(at.Add(10*time.Minute)) {
+		t.Errorf("GetLastPlayedAt() = %v, %v, want %v", last, err, at.Add(10*time.Minute))
+	}
+}
+
+// testListeners enrols two users, then removes one of them.
+func testListeners(t *testing.T, r Repository) {
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Date(2023, 5, 1, 11, 0, 0, 0, time.UTC)}
+	for _, userID := range []string{"user-1", "user-2"} {
+		if err := r.InsertListener(userID, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listeners, err := r.GetListeners()
+	sort.Strings(listeners)
+	if err != nil || !reflect.DeepEqual(listeners, []string{"user-1", "user-2"}) {
+		t.Errorf("GetListeners() = %v, %v, want user-1 and user-2", listeners, err)
+	}
+	got, err := r.GetListenerToken("user-1")
+	if err != nil || got.RefreshToken != token.RefreshToken || !got.Expiry.Equal(token.Expiry) {
+		t.Errorf("GetListenerToken() = %+v, %v, want %+v", got, err, token)
+	}
+	if err := r.RemoveListener("user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetListenerToken("user-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetListenerToken(removed) error = %v, want ErrNotFound", err)
+	}
+}
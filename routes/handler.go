@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"github.com/MinhPhu0304/spotify/trace"
+)
+
+// endpoint is a typed route handler. ctx carries the request's span and
+// sessionID the session the request was made with, empty on public routes.
+type endpoint[T any] func(ctx context.Context, r *http.Request, sessionID string) (T, error)
+
+// responder is implemented by results that are not answered with JSON.
+type responder interface {
+	respond(w http.ResponseWriter, r *http.Request)
+}
+
+// redirect answers with a redirect to the URL.
+type redirect string
+
+func (u redirect) respond(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, string(u), http.StatusFound)
+}
+
+// status answers with the status code and no body.
+type status int
+
+func (s status) respond(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(int(s))
+}
+
+// gzipMinSize is the smallest body worth compressing.
+const gzipMinSize = 1024
+
+// handle adapts fn to an http.HandlerFunc. The request is traced under its
+// route pattern, errors are answered with HandleError and results are encoded
+// as JSON, gzipped when the client accepts it.
+func handle[T any](fn endpoint[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := trace.Start(r.Context(), "handler "+r.Method+" "+routePattern(r))
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		res, err := fn(r.Context(), r, r.Header.Get(SessionHeader))
+		if err != nil {
+			span.SetError(err)
+			HandleError(w, r, err)
+			return
+		}
+		if res, ok := any(res).(responder); ok {
+			res.respond(w, r)
+			return
+		}
+		writeJSON(w, r, res)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	resBody, err := json.Marshal(v)
+	if err != nil {
+		HandleError(w, r, errors.Wrap(err, "failed to encode response"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(resBody) < gzipMinSize || !acceptsGzip(r) {
+		w.Write(resBody)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	gz.Write(resBody)
+	gz.Close()
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		// gzip;q=0 explicitly refuses it
+		return strings.ReplaceAll(params, " ", "") != "q=0"
+	}
+	return false
+}
+
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return r.URL.Path
+}
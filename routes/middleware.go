@@ -1,17 +1,29 @@
 package routes
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+	"github.com/MinhPhu0304/spotify/service"
+	"github.com/MinhPhu0304/spotify/trace"
 )
 
-func MustHaveSpotifyToken() func(next http.Handler) http.Handler {
+// SessionHeader carries the session ID handed to the dashboard after login.
+const SessionHeader = "spotify-session"
+
+var errMissingSession = apperr.Define(apperr.ErrUnauthorized, "missing spotify session")
+
+func MustHaveSpotifySession() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			spotifyToken := r.Header.Get("spotify-token")
-			if spotifyToken == "" {
-				http.Error(w, "missing spotify token", http.StatusUnauthorized)
+			sessionID := r.Header.Get(SessionHeader)
+			if sessionID == "" {
+				HandleError(w, r, errMissingSession)
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -19,7 +31,111 @@ func MustHaveSpotifyToken() func(next http.Handler) http.Handler {
 	}
 }
 
-func HandleError(w http.ResponseWriter, errMsg string, err error, code int) {
-	sentry.CaptureException(err)
-	http.Error(w, errMsg, code)
+// WithTracing records every request as a server span, continuing the trace of
+// the caller, and in the server metrics.
+func WithTracing() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx, span := trace.StartServer(r, r.Method+" "+trace.TemplatePath(r.URL.Path))
+			defer span.End()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			// the route is only known once the request was routed
+			route := routePattern(r)
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetName(r.Method + " " + route)
+			span.SetAttribute("http.route", route)
+			span.SetHTTPStatus(status)
+			trace.RecordServer(ctx, r.Method, route, status, start)
+		})
+	}
+}
+
+// CacheStatusHeader says whether a response was served fresh, stale or from the network.
+const CacheStatusHeader = "X-Cache-Status"
+
+func WithCacheStatus() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, status := service.WithCacheStatus(r.Context())
+			next.ServeHTTP(&cacheStatusWriter{ResponseWriter: w, status: status}, r.WithContext(ctx))
+		})
+	}
+}
+
+// cacheStatusWriter sets the cache status header right before the headers are sent.
+type cacheStatusWriter struct {
+	http.ResponseWriter
+	status      func() service.CacheStatus
+	wroteHeader bool
+}
+
+func (w *cacheStatusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if st := w.status(); st != "" {
+			w.Header().Set(CacheStatusHeader, string(st))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheStatusWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// RequestIDHeader echoes the ID errors are reported with, to find them in logs.
+const RequestIDHeader = "X-Request-Id"
+
+// errorBody is the JSON body of every error response.
+type errorBody struct {
+	Code      apperr.Code `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+// HandleError answers err with the status and code of its apperr kind, errors
+// of no kind being internal errors. Only server side failures are reported to
+// sentry, the message of those is generic so internals do not leak.
+func HandleError(w http.ResponseWriter, r *http.Request, err error) {
+	e := apperr.From(err)
+	requestID := middleware.GetReqID(r.Context())
+	if e.Status >= http.StatusInternalServerError {
+		hub := sentry.GetHubFromContext(r.Context())
+		if hub == nil {
+			hub = sentry.CurrentHub()
+		}
+		hub.WithScope(func(scope *sentry.Scope) {
+			scope.SetTag("request_id", requestID)
+			scope.SetTag("error_code", string(e.Code))
+			hub.CaptureException(err)
+		})
+	}
+
+	resBody, _ := json.Marshal(errorBody{Code: e.Code, Message: e.Message, RequestID: requestID})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if requestID != "" {
+		w.Header().Set(RequestIDHeader, requestID)
+	}
+	w.WriteHeader(e.Status)
+	w.Write(resBody)
+}
+
+// LimitBodySize fails reading request bodies past n bytes.
+func LimitBodySize(n int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
 }
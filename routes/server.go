@@ -1,25 +1,34 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
-	"strings"
 	"time"
 
-	"github.com/getsentry/sentry-go"
 	sentryhttp "github.com/getsentry/sentry-go/http"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/pkg/errors"
+	spotifyapi "github.com/zmb3/spotify/v2"
 
+	"github.com/MinhPhu0304/spotify/apperr"
 	"github.com/MinhPhu0304/spotify/client/lastfm"
+	"github.com/MinhPhu0304/spotify/client/musicbrainz"
 	"github.com/MinhPhu0304/spotify/client/spotify"
 	"github.com/MinhPhu0304/spotify/repository"
 	"github.com/MinhPhu0304/spotify/service"
+	"github.com/MinhPhu0304/spotify/types"
 )
 
+// maxHistoryUpload bounds a streaming history upload. A data export holds a
+// handful of files of about 12MB each.
+const maxHistoryUpload = 128 << 20
+
 type Server struct {
-	service service.Service
+	service *service.Service
 	Handler http.Handler
 }
 
@@ -27,258 +36,283 @@ type Config struct {
 	SpotifyCallBackURI  string
 	SpotifyDashboardURI string
 	LastFMToken         string
+	// SpotifyPKCE completes logins with PKCE so no client secret is needed.
+	SpotifyPKCE bool
+	// Repository selects the cache backend: "memory" (default), "bolt" or "redis".
+	// Sessions and login states are stored there too, so production must use
+	// redis.
+	Repository string
+	// RepositoryPath is the database file used by the bolt backend.
+	RepositoryPath string
+	// RedisURL is the server used by the redis backend.
+	RedisURL string
+	// CacheStaleFor enables stale-while-revalidate: cached data is served for
+	// this long past its expiration while it is refreshed in the background.
+	CacheStaleFor time.Duration
+	// HistoryCollectInterval runs the listening history collector, which also
+	// imports linked last.fm scrobbles, alongside the server. Lambda freezes
+	// between requests, a scheduled invocation calls Service.CollectHistory
+	// there instead.
+	HistoryCollectInterval time.Duration
+	// MusicBrainzURL overrides the MusicBrainz web service artists are resolved with.
+	MusicBrainzURL string
+	// SpotifyRateLimit paces the calls to the Spotify API.
+	SpotifyRateLimit spotify.RateLimit
+}
+
+// CreateRepository opens the repository backend selected by config.
+func CreateRepository(config Config) (repository.Repository, error) {
+	opts := []repository.Option{repository.WithStaleWhileRevalidate(config.CacheStaleFor)}
+	switch config.Repository {
+	case "", "memory":
+		return repository.CreateInMemoryRepo(opts...), nil
+	case "bolt":
+		return repository.CreateBoltRepo(config.RepositoryPath, opts...)
+	case "redis":
+		return repository.CreateRedisRepo(config.RedisURL, opts...)
+	default:
+		return nil, errors.Errorf("unknown repository backend %q", config.Repository)
+	}
 }
 
-func CreateServer(config Config) Server {
-	repo := repository.CreateInMemoryRepo()
-	sc := spotify.NewSpotifyClient(config.SpotifyCallBackURI, "spotifyOauth", repo, config.SpotifyDashboardURI)
+// CreateService builds the service and the clients and repository it uses.
+func CreateService(config Config) (*service.Service, error) {
+	repo, err := CreateRepository(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create repository")
+	}
+	sc := spotify.NewSpotifyClient(config.SpotifyCallBackURI, repo, config.SpotifyDashboardURI, config.SpotifyPKCE, config.SpotifyRateLimit)
 	lc := lastfm.Client(config.LastFMToken)
-	srvc := service.NewService(sc, lc, repo)
+	mb := musicbrainz.Client(config.MusicBrainzURL)
+	return service.NewService(sc, lc, mb, repo), nil
+}
+
+func CreateServer(config Config) (Server, error) {
+	srvc, err := CreateService(config)
+	if err != nil {
+		return Server{}, err
+	}
+	if config.HistoryCollectInterval > 0 {
+		go srvc.RunHistoryCollector(context.Background(), config.HistoryCollectInterval)
+	}
 
 	// Create an instance of sentryhttp
 	sentryHandler := sentryhttp.New(sentryhttp.Options{Repanic: true})
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(time.Second * 60))
-	r.Use(cors.AllowAll().Handler)
+	r.Use(cors.Handler(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodHead, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{"*"},
+		// the dashboard reads them from cross-origin responses
+		ExposedHeaders: []string{CacheStatusHeader, RequestIDHeader},
+	}))
+	r.Use(sentryHandler.Handle)
+	r.Use(WithTracing())
 	s := Server{
-		service: *srvc,
+		service: srvc,
 		Handler: r,
 	}
 
 	// Public route - only needs standard middleware
 	r.Group(func(r chi.Router) {
-		r.HandleFunc("/callback", sentryHandler.HandleFunc(s.HandleCallback))
-		r.HandleFunc("/oauth/spotify", sentryHandler.HandleFunc(s.HandleLoginSpotify))
-		r.HandleFunc("/ping", s.HandlePing)
+		r.HandleFunc("/callback", handle(s.HandleCallback))
+		r.HandleFunc("/oauth/spotify", handle(s.HandleLoginSpotify))
+		r.HandleFunc("/ping", handle(s.HandlePing))
 	})
 
-	// Private route must have spotify token
+	// Private route must have spotify session
 	r.Group(func(r chi.Router) {
-		r.Use(MustHaveSpotifyToken())
-		r.Get("/personal/top_artists", sentryHandler.HandleFunc(s.HandleTopArtists))
-		r.Get("/personal/top_tracks", sentryHandler.HandleFunc(s.HandleTopTracks))
-		r.Get("/genres", sentryHandler.HandleFunc(s.HandleGetGenres))
-		r.Get("/personal/recently_played", sentryHandler.HandleFunc(s.HandleRecentlyPlayed))
-		r.Get("/artist/{id}", sentryHandler.HandleFunc(s.HandleGetArtist))
-		r.Get("/song/{id}", sentryHandler.HandleFunc(s.HandleGetSong))
-		r.Get("/artist/{id}/related-artists", sentryHandler.HandleFunc(s.HandleGetRelatedArtist))
+		r.Use(MustHaveSpotifySession())
+		r.Use(WithCacheStatus())
+		r.Get("/personal/top_artists", byHistoryRange(handle(s.HandleHistoryTopArtists), handle(s.HandleTopArtists)))
+		r.Get("/personal/top_tracks", byHistoryRange(handle(s.HandleHistoryTopTracks), handle(s.HandleTopTracks)))
+		r.Get("/genres", handle(s.HandleGetGenres))
+		r.Get("/personal/recently_played", handle(s.HandleRecentlyPlayed))
+		r.Get("/personal/history", handle(s.HandleHistory))
+		r.With(LimitBodySize(maxHistoryUpload)).Post("/personal/history/import", handle(s.HandleImportHistory))
+		r.Post("/personal/lastfm", handle(s.HandleLinkLastFM))
+		r.Get("/artist/{id}", handle(s.HandleGetArtist))
+		r.Get("/song/{id}", handle(s.HandleGetSong))
+		r.Get("/artist/{id}/related-artists", handle(s.HandleGetRelatedArtist))
 	})
 
-	return s
+	return s, nil
 }
 
-func (s *Server) HandleCallback(w http.ResponseWriter, r *http.Request) {
-	redirectURI, err := s.service.CompleteAuth(r.Context(), r)
-	if err != nil {
-		http.Error(w, "Failed to login to Spotify", http.StatusBadRequest)
-		return
-	}
-	http.Redirect(w, r, redirectURI, http.StatusFound)
+func (s *Server) HandleCallback(ctx context.Context, r *http.Request, _ string) (redirect, error) {
+	redirectURI, err := s.service.CompleteAuth(ctx, r)
+	return redirect(redirectURI), err
 }
 
-func (s *Server) HandlePing(w http.ResponseWriter, r *http.Request) {
-	http.StatusText(http.StatusOK)
+func (s *Server) HandlePing(ctx context.Context, r *http.Request, _ string) (status, error) {
+	return http.StatusOK, nil
 }
 
-func (s *Server) HandleTopArtists(w http.ResponseWriter, r *http.Request) {
-	span := sentry.TransactionFromContext(r.Context())
-	if span == nil {
-		span = sentry.StartSpan(r.Context(), r.Method+" "+r.URL.Path)
-	}
-	defer span.Finish()
-	spotifyToken := r.Header.Get("spotify-token")
-	topArtists, err := s.service.TopArtists(r.Context(), spotifyToken)
-
-	if err != nil && (strings.Contains(err.Error(), "The access token expired") || strings.Contains(err.Error(), "Invalid access token")) {
-		http.Error(w, "", http.StatusUnauthorized)
-		return
-	}
-
+func (s *Server) HandleTopArtists(ctx context.Context, r *http.Request, sessionID string) (types.Page[spotifyapi.FullArtist], error) {
+	timeRange, err := service.ParseTimeRange(r.URL.Query().Get("time_range"))
 	if err != nil {
-		HandleError(w, "failed to get top artists", err, http.StatusInternalServerError)
-		return
+		return types.Page[spotifyapi.FullArtist]{}, err
 	}
-
-	resBody, err := json.Marshal(topArtists)
+	page, err := service.ParsePageRequest(r.URL.Query())
 	if err != nil {
-		HandleError(w, "failed to request to spotify artist", err, http.StatusInternalServerError)
-		return
+		return types.Page[spotifyapi.FullArtist]{}, err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(resBody)
+	return s.service.TopArtists(ctx, sessionID, timeRange, page)
 }
 
-func (s *Server) HandleTopTracks(w http.ResponseWriter, r *http.Request) {
-	span := sentry.TransactionFromContext(r.Context())
-	if span == nil {
-		span = sentry.StartSpan(r.Context(), r.Method+" "+r.URL.Path)
+// HandleHistoryTopArtists serves top artists asked for a date range, see
+// isHistoryRange.
+func (s *Server) HandleHistoryTopArtists(ctx context.Context, r *http.Request, sessionID string) (types.Page[types.TopArtist], error) {
+	page, err := service.ParsePageRequest(r.URL.Query())
+	if err != nil {
+		return types.Page[types.TopArtist]{}, err
 	}
-	defer span.Finish()
-	spotifyToken := r.Header.Get("spotify-token")
-	topTracks, err := s.service.TopTracks(r.Context(), spotifyToken)
-
-	if err != nil && (strings.Contains(err.Error(), "The access token expired") || strings.Contains(err.Error(), "Invalid access token")) {
-		http.Error(w, "", http.StatusUnauthorized)
-		return
+	query, err := historyRangeQuery(r)
+	if err != nil {
+		return types.Page[types.TopArtist]{}, err
 	}
+	return s.service.HistoryTopArtists(ctx, sessionID, query, page)
+}
 
+func (s *Server) HandleTopTracks(ctx context.Context, r *http.Request, sessionID string) (types.Page[spotifyapi.FullTrack], error) {
+	timeRange, err := service.ParseTimeRange(r.URL.Query().Get("time_range"))
 	if err != nil {
-		HandleError(w, "failed to get top tracks", err, http.StatusInternalServerError)
-		return
+		return types.Page[spotifyapi.FullTrack]{}, err
 	}
-
-	resBody, err := json.Marshal(topTracks)
+	page, err := service.ParsePageRequest(r.URL.Query())
 	if err != nil {
-		sentry.CaptureException(err)
-		http.Error(w, "failed to request to spotify tracks", http.StatusInternalServerError)
-		return
+		return types.Page[spotifyapi.FullTrack]{}, err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(resBody)
+	return s.service.TopTracks(ctx, sessionID, timeRange, page)
 }
 
-func (s *Server) HandleRecentlyPlayed(w http.ResponseWriter, r *http.Request) {
-	span := sentry.TransactionFromContext(r.Context())
-	if span == nil {
-		span = sentry.StartSpan(r.Context(), r.Method+" "+r.URL.Path)
+// HandleHistoryTopTracks serves top tracks asked for a date range, see
+// isHistoryRange.
+func (s *Server) HandleHistoryTopTracks(ctx context.Context, r *http.Request, sessionID string) (types.Page[types.TopTrack], error) {
+	page, err := service.ParsePageRequest(r.URL.Query())
+	if err != nil {
+		return types.Page[types.TopTrack]{}, err
 	}
-	defer span.Finish()
-	spotifyToken := r.Header.Get("spotify-token")
-	recentlyPlayed, err := s.service.RecentTracks(r.Context(), spotifyToken)
-
-	if err != nil && (strings.Contains(err.Error(), "The access token expired") || strings.Contains(err.Error(), "Invalid access token")) {
-		http.Error(w, "", http.StatusUnauthorized)
-		return
+	query, err := historyRangeQuery(r)
+	if err != nil {
+		return types.Page[types.TopTrack]{}, err
 	}
+	return s.service.HistoryTopTracks(ctx, sessionID, query, page)
+}
 
+func (s *Server) HandleRecentlyPlayed(ctx context.Context, r *http.Request, sessionID string) (types.Page[spotifyapi.RecentlyPlayedItem], error) {
+	page, err := service.ParsePageRequest(r.URL.Query())
 	if err != nil {
-		HandleError(w, "failed to get recently played tracks", err, http.StatusInternalServerError)
-		return
+		return types.Page[spotifyapi.RecentlyPlayedItem]{}, err
 	}
+	return s.service.RecentTracks(ctx, sessionID, page)
+}
 
-	resBody, err := json.Marshal(recentlyPlayed)
+func (s *Server) HandleHistory(ctx context.Context, r *http.Request, sessionID string) ([]types.Play, error) {
+	query, err := service.ParsePlayQuery(r.URL.Query())
 	if err != nil {
-		sentry.CaptureException(err)
-		http.Error(w, "failed to request to recently played tracks", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(resBody)
+	return s.service.History(ctx, sessionID, query)
 }
 
-func (s *Server) HandleGetArtist(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	span := sentry.TransactionFromContext(ctx)
-	if span == nil {
-		span = sentry.StartSpan(ctx, r.Method+" "+"/artist/{id}")
+func (s *Server) HandleImportHistory(ctx context.Context, r *http.Request, sessionID string) (types.HistoryImport, error) {
+	var result types.HistoryImport
+	files, err := r.MultipartReader()
+	if err != nil {
+		return result, apperr.BadRequest("streaming history files must be uploaded as multipart/form-data")
 	}
-	defer span.Finish()
-	spotifyToken := r.Header.Get("spotify-token")
-	artistID := chi.URLParam(r, "id")
-	artistInfo, err := s.service.Artist(ctx, spotifyToken, artistID)
-
-	if err != nil && (strings.Contains(err.Error(), "The access token expired") || strings.Contains(err.Error(), "Invalid access token")) {
-		http.Error(w, "", http.StatusUnauthorized)
-		return
+	for {
+		part, err := files.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, apperr.Wrap(apperr.ErrBadRequest, err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		imported, err := s.service.ImportStreamingHistory(ctx, sessionID, part)
+		if err != nil {
+			return result, err
+		}
+		result.Imported += imported.Imported
+		result.Duplicates += imported.Duplicates
 	}
+	return result, nil
+}
 
-	if err != nil {
-		HandleError(w, "failed to get artist info", err, http.StatusInternalServerError)
-		return
+func (s *Server) HandleLinkLastFM(ctx context.Context, r *http.Request, sessionID string) (status, error) {
+	var body struct {
+		Username string `json:"username"`
 	}
-
-	resBody, err := json.Marshal(artistInfo)
-	if err != nil {
-		HandleError(w, "", err, http.StatusInternalServerError)
-		return
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Username == "" {
+		return 0, apperr.BadRequest("missing last.fm username")
+	}
+	if err := s.service.LinkLastFM(ctx, sessionID, body.Username); err != nil {
+		return 0, err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(resBody)
+	// scrobbles are imported by the history collector
+	return http.StatusAccepted, nil
 }
 
-func (s *Server) HandleGetRelatedArtist(w http.ResponseWriter, r *http.Request) {
-	span := sentry.TransactionFromContext(r.Context())
-	if span == nil {
-		span = sentry.StartSpan(r.Context(), r.Method+" "+"/artist/{id}/related-artists")
-	}
-	defer span.Finish()
-	spotifyToken := r.Header.Get("spotify-token")
+func (s *Server) HandleGetArtist(ctx context.Context, r *http.Request, sessionID string) (types.ArtistInfo, error) {
 	artistID := chi.URLParam(r, "id")
-	artistInfo, err := s.service.RelatedArtist(r.Context(), spotifyToken, artistID)
-
-	if err != nil && (strings.Contains(err.Error(), "The access token expired") || strings.Contains(err.Error(), "Invalid access token")) {
-		http.Error(w, "", http.StatusUnauthorized)
-		return
-	}
+	lang := service.ParseLanguage(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+	return s.service.Artist(ctx, sessionID, artistID, lang)
+}
 
-	if err != nil {
-		HandleError(w, "failed to get related artists", err, http.StatusInternalServerError)
-		return
-	}
+func (s *Server) HandleGetRelatedArtist(ctx context.Context, r *http.Request, sessionID string) ([]spotifyapi.FullArtist, error) {
+	artistID := chi.URLParam(r, "id")
+	return s.service.RelatedArtist(ctx, sessionID, artistID)
+}
 
-	resBody, err := json.Marshal(artistInfo)
-	if err != nil {
-		HandleError(w, "", err, http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(resBody)
+func (s *Server) HandleLoginSpotify(ctx context.Context, r *http.Request, _ string) (redirect, error) {
+	authURL, err := s.service.AuthURL()
+	return redirect(authURL), err
 }
 
-func (s *Server) HandleLoginSpotify(w http.ResponseWriter, r *http.Request) {
-	http.Redirect(w, r, s.service.AuthURL(), http.StatusFound)
+type genres struct {
+	Genres []string `json:"genres"`
 }
 
-func (s *Server) HandleGetGenres(w http.ResponseWriter, r *http.Request) {
-	span := sentry.TransactionFromContext(r.Context())
-	if span == nil {
-		span = sentry.StartSpan(r.Context(), r.Method+" "+"/artist/{id}/related-artists")
-	}
-	defer span.Finish()
-	spotifyToken := r.Header.Get("spotify-token")
-	g, err := s.service.Genres(r.Context(), spotifyToken)
-	if err != nil {
-		HandleError(w, "failed to get genres", err, http.StatusInternalServerError)
-		return
-	}
-	resBody, err := json.Marshal(struct {
-		Genres []string `json:"genres"`
-	}{Genres: g})
-	if err != nil {
-		HandleError(w, "", err, http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(resBody)
+func (s *Server) HandleGetGenres(ctx context.Context, r *http.Request, sessionID string) (genres, error) {
+	g, err := s.service.Genres(ctx, sessionID)
+	return genres{Genres: g}, err
 }
 
-func (s *Server) HandleGetSong(w http.ResponseWriter, r *http.Request) {
-	span := sentry.TransactionFromContext(r.Context())
-	if span == nil {
-		span = sentry.StartSpan(r.Context(), r.Method+" "+"/song/{id}")
-	}
-	defer span.Finish()
-	spotifyToken := r.Header.Get("spotify-token")
-	artistID := chi.URLParam(r, "id")
-	sDetails, err := s.service.SongDetails(r.Context(), spotifyToken, artistID)
+func (s *Server) HandleGetSong(ctx context.Context, r *http.Request, sessionID string) (types.Song, error) {
+	songID := chi.URLParam(r, "id")
+	return s.service.SongDetails(ctx, sessionID, songID)
+}
 
-	if err != nil && (strings.Contains(err.Error(), "The access token expired") || strings.Contains(err.Error(), "Invalid access token")) {
-		http.Error(w, "", http.StatusUnauthorized)
-		return
-	}
+// isHistoryRange tells whether top artists or tracks are asked for a date
+// range, which Spotify cannot answer, so they are ranked from the history.
+func isHistoryRange(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Get("from") != "" || q.Get("to") != ""
+}
 
-	if err != nil {
-		HandleError(w, "failed to get song info", err, http.StatusInternalServerError)
-		return
+// byHistoryRange serves requests for a date range with history and the others
+// with spotify.
+func byHistoryRange(history http.HandlerFunc, spotify http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isHistoryRange(r) {
+			history(w, r)
+			return
+		}
+		spotify(w, r)
 	}
+}
 
-	resBody, err := json.Marshal(sDetails)
-	if err != nil {
-		HandleError(w, "", err, http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(resBody)
+func historyRangeQuery(r *http.Request) (types.PlayQuery, error) {
+	query, err := service.ParsePlayQuery(r.URL.Query())
+	// limit pages the ranking rather than the plays it is computed from
+	query.Limit = 0
+	return query, err
 }
@@ -3,34 +3,51 @@ package service
 import (
 	"context"
 
-	"github.com/pkg/errors"
 	"github.com/zmb3/spotify/v2"
 
 	"github.com/MinhPhu0304/spotify/repository"
 	"github.com/MinhPhu0304/spotify/types"
 )
 
-func (s *Service) TopArtists(ctx context.Context, spotifyToken string) ([]spotify.FullArtist, error) {
-	if t, err := s.repo.GetTopArtists(spotifyToken); errors.Is(repository.ErrInvalidType, err) || errors.Is(repository.ErrNotFound, err) {
-		a, err := s.spotifyClient.TopArtists(ctx, spotifyToken, 50)
-		go s.repo.InsertTopArtist(spotifyToken, a)
-		return a, err
-	} else {
-		return t, nil
+func (s *Service) TopArtists(ctx context.Context, sessionID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullArtist], error) {
+	userID, err := s.spotifyClient.UserID(ctx, sessionID)
+	if err != nil {
+		return types.Page[spotify.FullArtist]{}, err
 	}
+	return cached(ctx, s, repository.TopArtistsKey(userID, timeRange, page), func() (types.Page[spotify.FullArtist], error) {
+		return s.repo.GetTopArtists(userID, timeRange, page)
+	}, func(ctx context.Context) (types.Page[spotify.FullArtist], error) {
+		a, err := s.spotifyClient.TopArtists(ctx, sessionID, timeRange, page)
+		if err != nil {
+			return a, err
+		}
+		go s.repo.InsertTopArtist(userID, a, timeRange, page)
+		return a, nil
+	})
 }
 
-func (s *Service) Artist(ctx context.Context, token string, artistID string) (types.ArtistInfo, error) {
-	if a, err := s.repo.GetArtistInfo(artistID); errors.Is(repository.ErrInvalidType, err) || errors.Is(repository.ErrNotFound, err) {
-		info, err := s.spotifyClient.Artist(ctx, token, artistID, s.lastFMClient)
-		go s.repo.InsertArtistInfo(&info)
-		return info, err
-	} else {
-		return *a, nil
-	}
+// Artist returns the artist with their bio in lang, see ParseLanguage.
+func (s *Service) Artist(ctx context.Context, sessionID string, artistID string, lang string) (types.ArtistInfo, error) {
+	return cached(ctx, s, repository.ArtistInfoKey(artistID, lang), func() (types.ArtistInfo, error) {
+		a, err := s.repo.GetArtistInfo(artistID, lang)
+		if a == nil {
+			return types.ArtistInfo{}, err
+		}
+		return *a, err
+	}, func(ctx context.Context) (types.ArtistInfo, error) {
+		info, err := s.spotifyClient.Artist(ctx, sessionID, artistID, lang, s.lastFMClient, s.musicBrainzClient)
+		if err != nil {
+			return info, err
+		}
+		if !info.Degraded {
+			// degraded artists are fetched again until their bio is found
+			go s.repo.InsertArtistInfo(&info, lang)
+		}
+		return info, nil
+	})
 }
 
-func (s *Service) RelatedArtist(ctx context.Context, token string, artistID string) ([]spotify.FullArtist, error) {
-	a, err := s.spotifyClient.RelatedArtist(ctx, token, artistID)
+func (s *Service) RelatedArtist(ctx context.Context, sessionID string, artistID string) ([]spotify.FullArtist, error) {
+	a, err := s.spotifyClient.RelatedArtist(ctx, sessionID, artistID)
 	return a, err
 }
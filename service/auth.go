@@ -9,6 +9,6 @@ func (s *Service) CompleteAuth(ctx context.Context, r *http.Request) (redirectUR
 	return s.spotifyClient.CompleteAuth(ctx, r)
 }
 
-func (s *Service) AuthURL() string {
+func (s *Service) AuthURL() (string, error) {
 	return s.spotifyClient.GetAuthURL()
 }
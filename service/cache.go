@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+	"github.com/MinhPhu0304/spotify/repository"
+)
+
+// CacheStatus tells how the data of a response was obtained.
+type CacheStatus string
+
+const (
+	CacheFresh   CacheStatus = "fresh"
+	CacheStale   CacheStatus = "stale"
+	CacheNetwork CacheStatus = "network"
+)
+
+// revalidateTimeout bounds background refreshes, which outlive the request
+// that triggered them.
+const revalidateTimeout = 30 * time.Second
+
+type cacheStatusKey struct{}
+
+type cacheStatusRecorder struct {
+	mu     sync.Mutex
+	status CacheStatus
+}
+
+// WithCacheStatus returns a context in which the service records how the
+// response is served, and a func reporting the recorded status. Only the
+// first lookup of a request is recorded since it is the one the response is
+// built from.
+func WithCacheStatus(ctx context.Context) (context.Context, func() CacheStatus) {
+	rec := &cacheStatusRecorder{}
+	return context.WithValue(ctx, cacheStatusKey{}, rec), func() CacheStatus {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return rec.status
+	}
+}
+
+func setCacheStatus(ctx context.Context, status CacheStatus) {
+	rec, ok := ctx.Value(cacheStatusKey{}).(*cacheStatusRecorder)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.status == "" {
+		rec.status = status
+	}
+}
+
+// cached serves key through get. Misses are fetched through coalesce, while
+// stale hits are returned right away and refreshed once in the background.
+func cached[T any](ctx context.Context, s *Service, key string, get func() (T, error), fetch func(ctx context.Context) (T, error)) (T, error) {
+	v, err := get()
+	switch {
+	case err == nil:
+		setCacheStatus(ctx, CacheFresh)
+		return v, nil
+	case errors.Is(err, repository.ErrStale):
+		setCacheStatus(ctx, CacheStale)
+		revalidate(ctx, s, key, fetch)
+		return v, nil
+	}
+
+	setCacheStatus(ctx, CacheNetwork)
+	v, leader, err := coalesce(ctx, s, key, fetch)
+	if err != nil && !leader && isSessionError(err) {
+		// the shared fetch was made with the session of another caller
+		return fetch(ctx)
+	}
+	return v, err
+}
+
+// isSessionError tells errors caused by the session a fetch was made with
+// rather than by what was fetched.
+func isSessionError(err error) bool {
+	return errors.Is(err, apperr.ErrUnauthorized) || errors.Is(err, apperr.ErrTokenExpired) || errors.Is(err, apperr.ErrForbidden)
+}
+
+// revalidate refreshes key in the background. Concurrent stale reads of the
+// same key share a single refresh.
+func revalidate[T any](ctx context.Context, s *Service, key string, fetch func(ctx context.Context) (T, error)) {
+	bgCtx := detach(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(bgCtx, revalidateTimeout)
+		defer cancel()
+		if _, _, err := coalesce(ctx, s, key, fetch); err != nil {
+			sentry.CaptureException(err)
+		}
+	}()
+}
+
+// detach returns a context for work outliving the request of ctx. It keeps a
+// copy of the request's sentry hub so errors are still reported against it.
+func detach(ctx context.Context) context.Context {
+	bgCtx := context.Background()
+	if hub := sentry.GetHubFromContext(ctx); hub != nil {
+		bgCtx = sentry.SetHubOnContext(bgCtx, hub.Clone())
+	}
+	return bgCtx
+}
@@ -2,6 +2,6 @@ package service
 
 import "context"
 
-func (s *Service) Genres(ctx context.Context, token string) ([]string, error) {
-	return s.spotifyClient.Genres(ctx, token)
+func (s *Service) Genres(ctx context.Context, sessionID string) ([]string, error) {
+	return s.spotifyClient.Genres(ctx, sessionID)
 }
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/pkg/errors"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+	"github.com/MinhPhu0304/spotify/client/spotify"
+	"github.com/MinhPhu0304/spotify/repository"
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+// CollectHistory appends the plays since the last collection to the history of
+// every user enrolled at login, then carries on importing the scrobbles of
+// those who linked a last.fm account.
+func (s *Service) CollectHistory(ctx context.Context) {
+	listeners, err := s.repo.GetListeners()
+	if err != nil {
+		sentry.CaptureException(err)
+		return
+	}
+	// Spotify only keeps the last 50 plays, they are collected for everyone
+	// before any time is spent on imports
+	collected := make([]string, 0, len(listeners))
+	for _, userID := range listeners {
+		err := s.collectUserHistory(ctx, userID)
+		if errors.Is(err, spotify.ErrSessionNotFound) || errors.Is(err, spotify.ErrSessionExpired) {
+			// collection resumes once the user logs in again
+			s.repo.RemoveListener(userID)
+			continue
+		}
+		if err != nil {
+			sentry.CaptureException(err)
+		}
+		collected = append(collected, userID)
+	}
+	for _, userID := range collected {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.importUserScrobbles(ctx, userID); err != nil {
+			sentry.CaptureException(err)
+		}
+	}
+}
+
+func (s *Service) collectUserHistory(ctx context.Context, userID string) error {
+	last, err := s.repo.GetLastPlayedAt(userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return errors.Wrap(err, "failed to get last collected play")
+	}
+	plays, err := s.spotifyClient.RecentPlaysAfter(ctx, userID, last)
+	if err != nil {
+		return err
+	}
+	_, err = s.repo.AppendPlays(userID, plays)
+	return errors.Wrap(err, "failed to append plays")
+}
+
+// RunHistoryCollector collects history every interval until ctx is done.
+// Spotify only returns the last 50 plays, so the interval has to be shorter
+// than the time it takes a user to listen to 50 tracks.
+func (s *Service) RunHistoryCollector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.CollectHistory(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// History returns plays from the collected history of the session's user,
+// newest first.
+func (s *Service) History(ctx context.Context, sessionID string, query types.PlayQuery) ([]types.Play, error) {
+	userID, err := s.spotifyClient.UserID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	plays, err := s.repo.GetPlays(userID, query)
+	return plays, errors.Wrap(err, "failed to get play history")
+}
+
+// ImportStreamingHistory adds the plays of a Spotify extended streaming history
+// file to the history of the session's user. Plays already in the history are
+// skipped, whether collected or imported before, so importing the same file
+// twice is harmless.
+func (s *Service) ImportStreamingHistory(ctx context.Context, sessionID string, r io.Reader) (types.HistoryImport, error) {
+	userID, err := s.spotifyClient.UserID(ctx, sessionID)
+	if err != nil {
+		return types.HistoryImport{}, err
+	}
+	plays, err := spotify.ParseStreamingHistory(r)
+	if err != nil {
+		return types.HistoryImport{}, err
+	}
+	return s.ImportPlays(userID, plays)
+}
+
+// importBatchSize is how many imported plays are checked against the history
+// at once, an export can hold years of listening.
+const importBatchSize = 1000
+
+// ImportPlays adds imported plays to the user's history. Plays already in it,
+// from this import or collected from another source, are skipped.
+func (s *Service) ImportPlays(userID string, plays []types.Play) (types.HistoryImport, error) {
+	var result types.HistoryImport
+	for len(plays) > 0 {
+		batch := plays
+		if len(batch) > importBatchSize {
+			batch = batch[:importBatchSize]
+		}
+		plays = plays[len(batch):]
+		fresh, err := s.withoutCollectedPlays(userID, batch)
+		if err != nil {
+			return result, err
+		}
+		added, err := s.repo.AppendPlays(userID, fresh)
+		if err != nil {
+			return result, errors.Wrap(err, "failed to append plays")
+		}
+		result.Imported += added
+		result.Duplicates += len(batch) - added
+	}
+	return result, nil
+}
+
+// HistoryTopTracks ranks the tracks of the session user's collected and
+// imported history matching query by play count.
+func (s *Service) HistoryTopTracks(ctx context.Context, sessionID string, query types.PlayQuery, page types.PageRequest) (types.Page[types.TopTrack], error) {
+	plays, err := s.History(ctx, sessionID, query)
+	if err != nil {
+		return types.Page[types.TopTrack]{}, err
+	}
+	tracks := make([]types.TopTrack, 0)
+	index := make(map[string]int)
+	for _, p := range plays {
+		key := p.TrackID
+		if key == "" {
+			key = strings.ToLower(p.TrackName + "\x00" + strings.Join(p.ArtistNames, "\x00"))
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(tracks)
+			index[key] = i
+			tracks = append(tracks, types.TopTrack{
+				TrackID:     p.TrackID,
+				TrackName:   p.TrackName,
+				ArtistNames: p.ArtistNames,
+				AlbumName:   p.AlbumName,
+			})
+		}
+		tracks[i].Plays++
+	}
+	sort.SliceStable(tracks, func(i, j int) bool {
+		return tracks[i].Plays > tracks[j].Plays
+	})
+	return historyPage(tracks, query, page), nil
+}
+
+// HistoryTopArtists ranks the artists of the session user's collected and
+// imported history matching query by play count.
+func (s *Service) HistoryTopArtists(ctx context.Context, sessionID string, query types.PlayQuery, page types.PageRequest) (types.Page[types.TopArtist], error) {
+	plays, err := s.History(ctx, sessionID, query)
+	if err != nil {
+		return types.Page[types.TopArtist]{}, err
+	}
+	artists := make([]types.TopArtist, 0)
+	index := make(map[string]int)
+	for _, p := range plays {
+		for _, name := range p.ArtistNames {
+			key := strings.ToLower(name)
+			i, ok := index[key]
+			if !ok {
+				i = len(artists)
+				index[key] = i
+				artists = append(artists, types.TopArtist{ArtistName: name})
+			}
+			artists[i].Plays++
+		}
+	}
+	sort.SliceStable(artists, func(i, j int) bool {
+		return artists[i].Plays > artists[j].Plays
+	})
+	return historyPage(artists, query, page), nil
+}
+
+// historyPage slices a ranking computed from history. Next keeps the date
+// range and filters so the following page is ranked over the same plays.
+func historyPage[T any](items []T, query types.PlayQuery, page types.PageRequest) types.Page[T] {
+	total := len(items)
+	if page.Offset >= total {
+		return types.Page[T]{Items: []T{}, Total: total}
+	}
+	items = items[page.Offset:]
+	if len(items) > page.Limit {
+		items = items[:page.Limit]
+	}
+	p := types.Page[T]{Items: items, Total: total}
+	if next := page.Offset + len(items); next < total {
+		q := url.Values{}
+		if !query.From.IsZero() {
+			q.Set("from", query.From.Format(time.RFC3339Nano))
+		}
+		if !query.To.IsZero() {
+			q.Set("to", query.To.Format(time.RFC3339Nano))
+		}
+		if query.Artist != "" {
+			q.Set("artist", query.Artist)
+		}
+		if query.Track != "" {
+			q.Set("track", query.Track)
+		}
+		p.Next = fmt.Sprintf("limit=%d&offset=%d", page.Limit, next)
+		if len(q) > 0 {
+			p.Next += "&" + q.Encode()
+		}
+	}
+	return p
+}
+
+// ParsePlayQuery reads the from, to, artist, track and limit query parameters.
+// Dates are RFC 3339 timestamps or YYYY-MM-DD days, a day used as the upper
+// bound includes the whole day. Limit defaults and is capped as in
+// ParsePageRequest, older plays are listed by moving to back.
+func ParsePlayQuery(q url.Values) (types.PlayQuery, error) {
+	query := types.PlayQuery{
+		Artist: q.Get("artist"),
+		Track:  q.Get("track"),
+		Limit:  defaultPageLimit,
+	}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if query.From, _, err = parseDate(v); err != nil {
+			return types.PlayQuery{}, apperr.BadRequest("from must be a date or an RFC 3339 time")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		var isDay bool
+		if query.To, isDay, err = parseDate(v); err != nil {
+			return types.PlayQuery{}, apperr.BadRequest("to must be a date or an RFC 3339 time")
+		}
+		if isDay {
+			query.To = query.To.Add(24*time.Hour - time.Nanosecond)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit < 1 || query.Limit > maxPageLimit {
+			return types.PlayQuery{}, apperr.BadRequest(fmt.Sprintf("limit must be between 1 and %d", maxPageLimit))
+		}
+	}
+	return query, nil
+}
+
+func parseDate(v string) (t time.Time, isDay bool, err error) {
+	if t, err = time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339Nano, v)
+	return t, false, err
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MinhPhu0304/spotify/client/spotify"
+)
+
+// ParseLanguage picks the language to show bios in, as the ISO 639-1 code
+// last.fm expects. The lang query parameter wins over the Accept-Language
+// header, anything else falls back to English. Only the primary subtag is kept
+// ("pt-BR" is "pt") so bios are not cached once per region.
+func ParseLanguage(lang string, acceptLanguage string) string {
+	if code, ok := languageCode(lang); ok {
+		return code
+	}
+	type weighted struct {
+		code string
+		q    float64
+	}
+	candidates := make([]weighted, 0)
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		code, ok := languageCode(tag)
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, weighted{code, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	if len(candidates) > 0 {
+		return candidates[0].code
+	}
+	return spotify.DefaultBioLang
+}
+
+func languageCode(tag string) (string, bool) {
+	primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	primary = strings.ToLower(primary)
+	if len(primary) != 2 {
+		return "", false
+	}
+	for _, c := range primary {
+		if c < 'a' || c > 'z' {
+			return "", false
+		}
+	}
+	return primary, true
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+	"github.com/MinhPhu0304/spotify/types"
+)
+
+const (
+	defaultPageLimit = 50
+	// maxPageLimit bounds how many upstream pages a single request can walk through.
+	maxPageLimit = 500
+)
+
+// ParsePageRequest reads the limit, offset, before and after query parameters.
+func ParsePageRequest(q url.Values) (types.PageRequest, error) {
+	page := types.PageRequest{Limit: defaultPageLimit}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if page.Limit, err = strconv.Atoi(v); err != nil || page.Limit < 1 || page.Limit > maxPageLimit {
+			return types.PageRequest{}, apperr.BadRequest(fmt.Sprintf("limit must be between 1 and %d", maxPageLimit))
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if page.Offset, err = strconv.Atoi(v); err != nil || page.Offset < 0 {
+			return types.PageRequest{}, apperr.BadRequest("offset must be a positive number")
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		if page.Before, err = strconv.ParseInt(v, 10, 64); err != nil || page.Before < 0 {
+			return types.PageRequest{}, apperr.BadRequest("before must be a unix timestamp in milliseconds")
+		}
+	}
+	if v := q.Get("after"); v != "" {
+		if page.After, err = strconv.ParseInt(v, 10, 64); err != nil || page.After < 0 {
+			return types.PageRequest{}, apperr.BadRequest("after must be a unix timestamp in milliseconds")
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/pkg/errors"
+
+	"github.com/MinhPhu0304/spotify/repository"
+	"github.com/MinhPhu0304/spotify/types"
+	"github.com/MinhPhu0304/spotify/types/lastfm"
+)
+
+// scrobbleImportTimeout bounds the scrobble import of a user in a single
+// collection. Progress is saved after every page, longer histories carry on
+// in the next collections.
+const scrobbleImportTimeout = time.Minute
+
+// duplicateWindow is how far apart a scrobble and a play of the same track
+// collected from Spotify can be and still be the same listen. Last.fm records
+// when a track started and Spotify when it was played, so they never match
+// exactly.
+const duplicateWindow = 10 * time.Minute
+
+// LinkLastFM links a last.fm account to the session's user. Its scrobbles are
+// imported by CollectHistory, which runs on a schedule rather than with the
+// request. Linking the same account again keeps the import's progress.
+func (s *Service) LinkLastFM(ctx context.Context, sessionID string, username string) error {
+	userID, err := s.spotifyClient.UserID(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	// fail early on unknown accounts rather than in the background
+	if _, err := s.lastFMClient.GetRecentTracks(ctx, username, time.Time{}, time.Time{}, 1); err != nil {
+		return errors.Wrapf(err, "failed to get last.fm scrobbles of %q", username)
+	}
+
+	link, err := s.repo.GetLastFMLink(userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return errors.Wrap(err, "failed to get last.fm link")
+	}
+	if !strings.EqualFold(link.Username, username) {
+		link = types.LastFMLink{Username: username}
+	}
+	return errors.Wrap(s.repo.InsertLastFMLink(userID, link), "failed to link last.fm user")
+}
+
+// importUserScrobbles imports the scrobbles of the user's linked last.fm
+// account for at most scrobbleImportTimeout, if they linked one.
+func (s *Service) importUserScrobbles(ctx context.Context, userID string) error {
+	link, err := s.repo.GetLastFMLink(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get last.fm link")
+	}
+	ctx, cancel := context.WithTimeout(ctx, scrobbleImportTimeout)
+	defer cancel()
+	_, err = s.ImportScrobbles(ctx, userID, link)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// picked up where it stopped by the next collection
+		return nil
+	}
+	return err
+}
+
+// ImportScrobbles adds the scrobbles of link made since its last import to the
+// user's history, resolving them to Spotify tracks where possible. Scrobbles of
+// plays already collected from Spotify are skipped. Pages are imported oldest
+// first and the progress saved after each of them, so an import cut short
+// resumes where it stopped.
+func (s *Service) ImportScrobbles(ctx context.Context, userID string, link types.LastFMLink) (types.HistoryImport, error) {
+	var result types.HistoryImport
+	// a fixed upper bound keeps pages stable while new scrobbles come in
+	until := time.Now()
+	// last.fm lists newest first, the first page tells how many there are
+	first, err := s.lastFMClient.GetRecentTracks(ctx, link.Username, link.ImportedUntil, until, 1)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to get last.fm scrobbles")
+	}
+	resolve := true
+	for page := first.RecentTracks.Attr.TotalPages; page >= 1; page-- {
+		recent := first
+		if page > 1 {
+			if recent, err = s.lastFMClient.GetRecentTracks(ctx, link.Username, link.ImportedUntil, until, page); err != nil {
+				return result, errors.Wrap(err, "failed to get last.fm scrobbles")
+			}
+		}
+		plays := playsFromScrobbles(recent.RecentTracks.Track)
+		if resolve {
+			if err := s.spotifyClient.ResolveTrackIDs(ctx, userID, plays); err != nil {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				// keep importing by name only
+				sentry.CaptureException(err)
+				resolve = false
+			}
+		}
+		fresh, err := s.withoutCollectedPlays(userID, plays)
+		if err != nil {
+			return result, err
+		}
+		added, err := s.repo.AppendPlays(userID, fresh)
+		if err != nil {
+			return result, errors.Wrap(err, "failed to append plays")
+		}
+		result.Imported += added
+		result.Duplicates += len(plays) - added
+
+		if page > 1 && len(plays) > 0 {
+			// scrobbles come newest first, the pages left are all newer
+			link.ImportedUntil = plays[0].PlayedAt
+			if err := s.repo.InsertLastFMLink(userID, link); err != nil {
+				return result, errors.Wrap(err, "failed to save last.fm import progress")
+			}
+		}
+	}
+
+	link.ImportedUntil = until
+	return result, errors.Wrap(s.repo.InsertLastFMLink(userID, link), "failed to save last.fm import progress")
+}
+
+// withoutCollectedPlays drops the plays matching a play already in the user's
+// history from another source. Plays of the same source are deduplicated by
+// AppendPlays.
+func (s *Service) withoutCollectedPlays(userID string, plays []types.Play) ([]types.Play, error) {
+	if len(plays) == 0 {
+		return plays, nil
+	}
+	from, to := plays[0].PlayedAt, plays[0].PlayedAt
+	for _, p := range plays {
+		if p.PlayedAt.Before(from) {
+			from = p.PlayedAt
+		}
+		if p.PlayedAt.After(to) {
+			to = p.PlayedAt
+		}
+	}
+	existing, err := s.repo.GetPlays(userID, types.PlayQuery{
+		From: from.Add(-duplicateWindow),
+		To:   to.Add(duplicateWindow),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get play history")
+	}
+	fresh := make([]types.Play, 0, len(plays))
+	for _, play := range plays {
+		if !hasSameListen(existing, play) {
+			fresh = append(fresh, play)
+		}
+	}
+	return fresh, nil
+}
+
+func hasSameListen(plays []types.Play, scrobble types.Play) bool {
+	for _, p := range plays {
+		if p.Source == scrobble.Source {
+			continue
+		}
+		d := p.PlayedAt.Sub(scrobble.PlayedAt)
+		if d < -duplicateWindow || d > duplicateWindow {
+			continue
+		}
+		if (p.TrackID != "" && p.TrackID == scrobble.TrackID) || strings.EqualFold(p.TrackName, scrobble.TrackName) {
+			return true
+		}
+	}
+	return false
+}
+
+func playsFromScrobbles(tracks []lastfm.RecentTrack) []types.Play {
+	plays := make([]types.Play, 0, len(tracks))
+	for _, t := range tracks {
+		p := types.Play{
+			PlayedAt:  time.Unix(t.Date.UTS, 0).UTC(),
+			TrackName: t.Name,
+			AlbumName: t.Album.Text,
+			Source:    types.PlaySourceLastFM,
+		}
+		if t.Artist.Text != "" {
+			p.ArtistNames = []string{t.Artist.Text}
+		}
+		plays = append(plays, p)
+	}
+	return plays
+}
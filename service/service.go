@@ -1,21 +1,74 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"golang.org/x/sync/singleflight"
+
 	"github.com/MinhPhu0304/spotify/client/lastfm"
+	"github.com/MinhPhu0304/spotify/client/musicbrainz"
 	"github.com/MinhPhu0304/spotify/client/spotify"
 	"github.com/MinhPhu0304/spotify/repository"
 )
 
+// fetchTimeout bounds fetches shared through coalesce, which do not end with
+// the request that started them.
+const fetchTimeout = 30 * time.Second
+
 type Service struct {
-	spotifyClient *spotify.Spotify
-	lastFMClient  lastfm.LastFMClient
-	repo          repository.Repository
+	spotifyClient     *spotify.Spotify
+	lastFMClient      lastfm.LastFMClient
+	musicBrainzClient musicbrainz.MusicBrainzClient
+	repo              repository.Repository
+	// inflight deduplicates concurrent cache misses for the same repository key.
+	inflight singleflight.Group
 }
 
-func NewService(spotifyClient *spotify.Spotify, lastFMClient lastfm.LastFMClient, repo repository.Repository) *Service {
+func NewService(spotifyClient *spotify.Spotify, lastFMClient lastfm.LastFMClient, musicBrainzClient musicbrainz.MusicBrainzClient, repo repository.Repository) *Service {
 	return &Service{
-		spotifyClient: spotifyClient,
-		lastFMClient:  lastFMClient,
-		repo:          repo,
+		spotifyClient:     spotifyClient,
+		lastFMClient:      lastFMClient,
+		musicBrainzClient: musicBrainzClient,
+		repo:              repo,
+	}
+}
+
+// coalesce runs fetch once for every concurrent caller asking for the same key
+// and hands all of them the shared result. fetch runs detached from the
+// request of the caller that started it, so that caller going away does not
+// fail the others, while every caller stops waiting once its own ctx is done.
+// The bool tells whether the result was fetched for this caller.
+func coalesce[T any](ctx context.Context, s *Service, key string, fetch func(ctx context.Context) (T, error)) (T, bool, error) {
+	bgCtx := detach(ctx)
+	// set by the goroutine of fetch, read once its result is received
+	leader := false
+	ch := s.inflight.DoChan(key, func() (res interface{}, err error) {
+		leader = true
+		ctx, cancel := context.WithTimeout(bgCtx, fetchTimeout)
+		defer cancel()
+		// DoChan runs fetch on its own goroutine, which the router's recoverer
+		// does not cover
+		defer func() {
+			if r := recover(); r != nil {
+				hub := sentry.GetHubFromContext(ctx)
+				if hub == nil {
+					hub = sentry.CurrentHub()
+				}
+				hub.RecoverWithContext(ctx, r)
+				err = fmt.Errorf("panic while fetching %s: %v", key, r)
+			}
+		}()
+		return fetch(ctx)
+	})
+	select {
+	case res := <-ch:
+		v, _ := res.Val.(T)
+		return v, leader, res.Err
+	case <-ctx.Done():
+		var v T
+		return v, false, ctx.Err()
 	}
 }
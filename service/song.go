@@ -3,21 +3,75 @@ package service
 import (
 	"context"
 	"errors"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/zmb3/spotify/v2"
 
+	"github.com/MinhPhu0304/spotify/client/lastfm"
 	"github.com/MinhPhu0304/spotify/repository"
 	"github.com/MinhPhu0304/spotify/types"
+	lastfmtype "github.com/MinhPhu0304/spotify/types/lastfm"
 )
 
-func (s *Service) SongDetails(ctx context.Context, spotifyToken string, trackID string) (types.Song, error) {
-	if s, _ := s.repo.GetSong(trackID); s != nil {
-		return *s, nil
+// lastFMListener is the last.fm account linked to the caller, empty when
+// there is none.
+type lastFMListener struct {
+	userID   string
+	username string
+}
+
+func (s *Service) SongDetails(ctx context.Context, sessionID string, trackID string) (types.Song, error) {
+	listener := s.lastFMListener(ctx, sessionID)
+	// the caller's stats come with the song when this request fetches it
+	var mu sync.Mutex
+	var fetched *types.UserTrackStats
+	song, err := cached(ctx, s, repository.SongKey(trackID), func() (types.Song, error) {
+		song, err := s.repo.GetSong(trackID)
+		if song == nil {
+			return types.Song{}, err
+		}
+		return *song, err
+	}, func(ctx context.Context) (types.Song, error) {
+		song, stats, err := s.fetchSongDetails(ctx, sessionID, trackID, listener)
+		mu.Lock()
+		fetched = stats
+		mu.Unlock()
+		return song, err
+	})
+	if err != nil || listener.username == "" {
+		return song, err
+	}
+	mu.Lock()
+	song.UserStats = fetched
+	mu.Unlock()
+	if song.UserStats == nil {
+		song.UserStats = s.userTrackStats(ctx, listener, song.Detail)
 	}
+	return song, nil
+}
 
+func (s *Service) lastFMListener(ctx context.Context, sessionID string) lastFMListener {
+	userID, err := s.spotifyClient.UserID(ctx, sessionID)
+	if err != nil {
+		return lastFMListener{}
+	}
+	link, err := s.repo.GetLastFMLink(userID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			sentry.CaptureException(err)
+		}
+		return lastFMListener{}
+	}
+	return lastFMListener{userID: userID, username: link.Username}
+}
+
+// fetchSongDetails fails when the track itself cannot be fetched, the rest of
+// the song is best effort. The listener's own stats are returned apart from
+// the song as the song is cached for every user.
+func (s *Service) fetchSongDetails(ctx context.Context, sessionID string, trackID string, listener lastFMListener) (types.Song, *types.UserTrackStats, error) {
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 
@@ -28,7 +82,7 @@ func (s *Service) SongDetails(ctx context.Context, spotifyToken string, trackID
 	go func(ctx context.Context) {
 		defer wg.Done()
 		defer sentry.RecoverWithContext(ctx)
-		r, err := s.spotifyClient.Recommendation(ctx, spotifyToken, trackID)
+		r, err := s.spotifyClient.Recommendation(ctx, sessionID, trackID)
 		if err != nil {
 			sentry.CaptureException(err)
 		}
@@ -43,7 +97,7 @@ func (s *Service) SongDetails(ctx context.Context, spotifyToken string, trackID
 	defer featureCancel()
 	go func(ctx context.Context) {
 		defer wg.Done()
-		if f := s.getTrackFeatures(ctx, spotifyToken, trackID); f != nil {
+		if f := s.getTrackFeatures(ctx, sessionID, trackID); f != nil {
 			mu.Lock()
 			feats = f[trackID]
 			mu.Unlock()
@@ -51,46 +105,138 @@ func (s *Service) SongDetails(ctx context.Context, spotifyToken string, trackID
 	}(featurectx)
 
 	track := spotify.FullTrack{}
+	var trackErr error
+	// last.fm looks tracks up by name, so it waits for the spotify track
+	trackReady := make(chan spotify.FullTrack, 1)
 	wg.Add(1)
 	trackCtx, trackCancel := context.WithTimeout(ctx, 2*time.Second)
 	defer trackCancel()
 	go func(ctx context.Context) {
 		defer wg.Done()
-		if t := s.getTrack(ctx, spotifyToken, trackID); t != nil {
-			mu.Lock()
-			track = *t
-			mu.Unlock()
+		defer close(trackReady)
+		t, err := s.getTrack(ctx, sessionID, trackID)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil || t == nil {
+			trackErr = err
+			return
 		}
+		track = *t
+		trackReady <- *t
 	}(trackCtx)
 
+	var stats *types.TrackStats
+	var userStats *types.UserTrackStats
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.RecoverWithContext(ctx)
+		t, ok := <-trackReady
+		if !ok || len(t.Artists) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		info, err := s.lastFMClient.GetTrackInfo(ctx, t.Artists[0].Name, t.Name, listener.username)
+		if err != nil {
+			if !errors.Is(err, lastfm.ErrNotFound) {
+				sentry.CaptureException(err)
+			}
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		stats = trackStats(info.Track)
+		if listener.username != "" {
+			userStats = userTrackStats(listener.username, info.Track)
+			go s.repo.InsertUserTrackStats(listener.userID, trackID, userStats)
+		}
+	}()
+
 	wg.Wait()
+	if trackErr != nil {
+		return types.Song{}, nil, trackErr
+	}
 	song := types.Song{
 		Detail:          track,
 		Features:        feats,
 		Recommendations: rec,
+		LastFM:          stats,
 	}
 	go s.repo.InsertSong(&song)
-	return song, nil
+	return song, userStats, nil
 }
 
-func (s *Service) getTrack(ctx context.Context, spotifyToken string, trackID string) *spotify.FullTrack {
-	defer sentry.RecoverWithContext(ctx)
-	if t, err := s.repo.GetSpotifyFullTrack(trackID); errors.Is(repository.ErrInvalidType, err) || errors.Is(repository.ErrNotFound, err) {
-		t, err := s.spotifyClient.Track(ctx, spotifyToken, trackID)
-		if err != nil {
+// userTrackStats returns how the caller's last.fm account listened to track,
+// nil when last.fm cannot tell.
+func (s *Service) userTrackStats(ctx context.Context, listener lastFMListener, track spotify.FullTrack) *types.UserTrackStats {
+	trackID := track.ID.String()
+	if stats, err := s.repo.GetUserTrackStats(listener.userID, trackID); stats != nil {
+		return stats
+	} else if !errors.Is(err, repository.ErrNotFound) && !errors.Is(err, repository.ErrInvalidType) {
+		sentry.CaptureException(err)
+	}
+	if len(track.Artists) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	info, err := s.lastFMClient.GetTrackInfo(ctx, track.Artists[0].Name, track.Name, listener.username)
+	if err != nil {
+		if !errors.Is(err, lastfm.ErrNotFound) {
 			sentry.CaptureException(err)
-			return nil
 		}
-		go s.repo.InsertSpotifyFullTrack(&t)
-		return &t
-	} else {
-		return t
+		return nil
+	}
+	stats := userTrackStats(listener.username, info.Track)
+	go s.repo.InsertUserTrackStats(listener.userID, trackID, stats)
+	return stats
+}
+
+func trackStats(t *lastfmtype.Track) *types.TrackStats {
+	stats := &types.TrackStats{
+		URL:  t.URL,
+		Tags: make([]string, 0, len(t.TopTags.Tag)),
+	}
+	stats.Listeners, _ = strconv.Atoi(t.Listeners)
+	stats.Playcount, _ = strconv.Atoi(t.Playcount)
+	for _, tag := range t.TopTags.Tag {
+		stats.Tags = append(stats.Tags, tag.Name)
+	}
+	if t.Wiki != nil {
+		stats.WikiSummary = lastfm.SanitizeBio(t.Wiki.Content, t.Wiki.Summary).Summary
+	}
+	return stats
+}
+
+func userTrackStats(username string, t *lastfmtype.Track) *types.UserTrackStats {
+	playcount, _ := t.UserPlaycount.Int64()
+	return &types.UserTrackStats{
+		Username:  username,
+		Playcount: int(playcount),
+		Loved:     t.UserLoved.String() == "1",
+	}
+}
+
+func (s *Service) getTrack(ctx context.Context, sessionID string, trackID string) (*spotify.FullTrack, error) {
+	defer sentry.RecoverWithContext(ctx)
+	if t, err := s.repo.GetSpotifyFullTrack(trackID); t != nil {
+		return t, nil
+	} else if !errors.Is(err, repository.ErrInvalidType) && !errors.Is(err, repository.ErrNotFound) {
+		sentry.CaptureException(err)
+	}
+	t, err := s.spotifyClient.Track(ctx, sessionID, trackID)
+	if err != nil {
+		return nil, err
 	}
+	go s.repo.InsertSpotifyFullTrack(&t)
+	return &t, nil
 }
 
-func (s *Service) getTrackFeatures(ctx context.Context, spotifyToken string, trackID string) map[string]spotify.AudioFeatures {
+func (s *Service) getTrackFeatures(ctx context.Context, sessionID string, trackID string) map[string]spotify.AudioFeatures {
 	defer sentry.RecoverWithContext(ctx)
-	f, err := s.spotifyClient.TrackFeatures(ctx, spotifyToken, []spotify.ID{spotify.ID(trackID)})
+	f, err := s.spotifyClient.TrackFeatures(ctx, sessionID, []spotify.ID{spotify.ID(trackID)})
 	if err != nil {
 		sentry.CaptureException(err)
 		return f
@@ -2,28 +2,51 @@ package service
 
 import (
 	"context"
+	"fmt"
 
-	"github.com/pkg/errors"
 	"github.com/zmb3/spotify/v2"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+	spotifyclient "github.com/MinhPhu0304/spotify/client/spotify"
+	"github.com/MinhPhu0304/spotify/repository"
+	"github.com/MinhPhu0304/spotify/types"
 )
 
-func (s *Service) RecentTracks(ctx context.Context, spotifyToken string) ([]spotify.RecentlyPlayedItem, error) {
-	if spotifyToken == "" {
-		return []spotify.RecentlyPlayedItem{}, errors.New("missing spotify token")
+func (s *Service) RecentTracks(ctx context.Context, sessionID string, page types.PageRequest) (types.Page[spotify.RecentlyPlayedItem], error) {
+	if sessionID == "" {
+		return types.Page[spotify.RecentlyPlayedItem]{}, spotifyclient.ErrSessionNotFound
 	}
 
-	t, err := s.spotifyClient.RecentTracks(ctx, spotifyToken)
+	t, err := s.spotifyClient.RecentTracks(ctx, sessionID, page)
 	return t, err
 }
 
-func (s *Service) TopTracks(ctx context.Context, spotifyToken string) ([]spotify.FullTrack, error) {
-	t, err := s.repo.GetUserTopTracks(spotifyToken)
-
-	if err == nil {
-		return t, nil
+func (s *Service) TopTracks(ctx context.Context, sessionID string, timeRange spotify.Range, page types.PageRequest) (types.Page[spotify.FullTrack], error) {
+	userID, err := s.spotifyClient.UserID(ctx, sessionID)
+	if err != nil {
+		return types.Page[spotify.FullTrack]{}, err
 	}
+	return cached(ctx, s, repository.UserTopTracksKey(userID, timeRange, page), func() (types.Page[spotify.FullTrack], error) {
+		return s.repo.GetUserTopTracks(userID, timeRange, page)
+	}, func(ctx context.Context) (types.Page[spotify.FullTrack], error) {
+		t, err := s.spotifyClient.TopTracks(ctx, sessionID, timeRange, page)
+		if err != nil {
+			return t, err
+		}
+		go s.repo.InsertUserTopTracks(t, userID, timeRange, page)
+		return t, nil
+	})
+}
 
-	t, err = s.spotifyClient.TopTracks(ctx, spotifyToken, spotify.Limit(50))
-	go s.repo.InsertUserTopTracks(t, spotifyToken)
-	return t, err
+// ParseTimeRange validates the time_range query parameter, defaulting to
+// Spotify's own medium term range.
+func ParseTimeRange(timeRange string) (spotify.Range, error) {
+	switch r := spotify.Range(timeRange); r {
+	case "":
+		return spotify.MediumTermRange, nil
+	case spotify.ShortTermRange, spotify.MediumTermRange, spotify.LongTermRange:
+		return r, nil
+	default:
+		return "", apperr.BadRequest(fmt.Sprintf("invalid time range %q", timeRange))
+	}
 }
@@ -0,0 +1,128 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+)
+
+// ErrCircuitOpen is returned without calling the upstream while its host is
+// considered down.
+var ErrCircuitOpen = apperr.Define(apperr.ErrUpstreamUnavailable, "upstream circuit open")
+
+// BreakerConfig configures the circuit breaker of an upstream host.
+type BreakerConfig struct {
+	// Failures is how many requests in a row have to fail for the circuit
+	// to open.
+	Failures int
+	// OpenFor is how long requests fail fast before a single probe is let
+	// through to find out whether the host is back.
+	OpenFor time.Duration
+}
+
+// DefaultBreakerConfig is used by clients created without WithBreaker. It can
+// be replaced at startup, before any client is created.
+var DefaultBreakerConfig = BreakerConfig{Failures: 5, OpenFor: 30 * time.Second}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type breaker struct {
+	config   BreakerConfig
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// breakers holds the breaker of every upstream host and config. They are
+// shared by the clients configured alike since Spotify clients are created
+// for every request, clients configured otherwise get breakers of their own.
+var breakers sync.Map
+
+type breakerKey struct {
+	host   string
+	config BreakerConfig
+}
+
+func hostBreaker(host string, config BreakerConfig) *breaker {
+	b, _ := breakers.LoadOrStore(breakerKey{host, config}, &breaker{config: config})
+	return b.(*breaker)
+}
+
+// allow tells whether a request may be sent. Once the circuit has been open
+// for long enough, the first request is let through as the probe and the
+// others keep failing until it completes.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.config.OpenFor {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		return false
+	}
+	return true
+}
+
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.config.Failures {
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release lets another request probe the host when the probe was canceled.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpen
+	}
+}
+
+// breakerTransport fails fast on hosts whose circuit is open.
+type breakerTransport struct {
+	http.RoundTripper
+	config BreakerConfig
+}
+
+func newBreakerTransport(roundTripper http.RoundTripper, config BreakerConfig) *breakerTransport {
+	return &breakerTransport{RoundTripper: roundTripper, config: config}
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := hostBreaker(req.URL.Host, t.config)
+	if !b.allow() {
+		return nil, ErrCircuitOpen
+	}
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, which says nothing about the host
+		b.release()
+		return resp, err
+	}
+	b.record(err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
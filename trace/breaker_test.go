@@ -0,0 +1,218 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// upstream answers with the status set last, or fails with err when set.
+type upstream struct {
+	status int32
+	calls  int32
+	err    error
+}
+
+func (u *upstream) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&u.calls, 1)
+	if u.err != nil {
+		return nil, u.err
+	}
+	return &http.Response{StatusCode: int(atomic.LoadInt32(&u.status)), Body: http.NoBody}, nil
+}
+
+var testBreakerConfig = BreakerConfig{Failures: 3, OpenFor: 50 * time.Millisecond}
+
+// newBreakerTest returns a breaker transport over u and requests to a host of
+// the test's own with a closed circuit, breakers being shared by host.
+func newBreakerTest(t *testing.T, u http.RoundTripper) (http.RoundTripper, func() *http.Request) {
+	host := t.Name() + ".test"
+	breakers.Delete(breakerKey{host, testBreakerConfig})
+	transport := newBreakerTransport(u, testBreakerConfig)
+	return transport, func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		return req
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	u := &upstream{status: http.StatusInternalServerError}
+	transport, req := newBreakerTest(t, u)
+
+	for i := 0; i < testBreakerConfig.Failures; i++ {
+		if _, err := transport.RoundTrip(req()); err != nil {
+			t.Fatalf("request %d error = %v, want the 500 passed on", i, err)
+		}
+	}
+	if _, err := transport.RoundTrip(req()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("request after %d failures error = %v, want ErrCircuitOpen", testBreakerConfig.Failures, err)
+	}
+	if u.calls != int32(testBreakerConfig.Failures) {
+		t.Errorf("upstream got %d requests, want %d", u.calls, testBreakerConfig.Failures)
+	}
+}
+
+func TestBreakerCountsFailuresInARow(t *testing.T) {
+	u := &upstream{status: http.StatusInternalServerError}
+	transport, req := newBreakerTest(t, u)
+
+	for i := 0; i < testBreakerConfig.Failures-1; i++ {
+		transport.RoundTrip(req())
+	}
+	atomic.StoreInt32(&u.status, http.StatusOK)
+	transport.RoundTrip(req())
+	atomic.StoreInt32(&u.status, http.StatusNotFound)
+	transport.RoundTrip(req())
+	atomic.StoreInt32(&u.status, http.StatusInternalServerError)
+	for i := 0; i < testBreakerConfig.Failures-1; i++ {
+		transport.RoundTrip(req())
+	}
+	// neither the success nor the 404 let the failures add up
+	if _, err := transport.RoundTrip(req()); errors.Is(err, ErrCircuitOpen) {
+		t.Error("circuit open without enough failures in a row")
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	u := &upstream{status: http.StatusInternalServerError}
+	transport, req := newBreakerTest(t, u)
+	for i := 0; i < testBreakerConfig.Failures; i++ {
+		transport.RoundTrip(req())
+	}
+
+	// the probe fails and the circuit opens for another OpenFor
+	time.Sleep(testBreakerConfig.OpenFor)
+	if _, err := transport.RoundTrip(req()); err != nil {
+		t.Fatalf("probe error = %v, want the 500 passed on", err)
+	}
+	if _, err := transport.RoundTrip(req()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("request after a failed probe error = %v, want ErrCircuitOpen", err)
+	}
+
+	// the probe succeeds and the circuit closes
+	atomic.StoreInt32(&u.status, http.StatusOK)
+	time.Sleep(testBreakerConfig.OpenFor)
+	for i := 0; i < 2; i++ {
+		if resp, err := transport.RoundTrip(req()); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d after a successful probe = %v, want 200", i, err)
+		}
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	u := &upstream{status: http.StatusInternalServerError}
+	probing := make(chan struct{})
+	done := make(chan struct{})
+	var probe int32
+	transport, req := newBreakerTest(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if atomic.LoadInt32(&probe) == 1 {
+			close(probing)
+			<-done
+		}
+		return u.RoundTrip(r)
+	}))
+	for i := 0; i < testBreakerConfig.Failures; i++ {
+		transport.RoundTrip(req())
+	}
+
+	time.Sleep(testBreakerConfig.OpenFor)
+	atomic.StoreInt32(&probe, 1)
+	go transport.RoundTrip(req())
+	<-probing
+	// requests made while the probe is in flight keep failing fast
+	if _, err := transport.RoundTrip(req()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("request during the probe error = %v, want ErrCircuitOpen", err)
+	}
+	close(done)
+}
+
+func TestBreakerReleasesCanceledProbe(t *testing.T) {
+	u := &upstream{status: http.StatusInternalServerError}
+	transport, req := newBreakerTest(t, u)
+	for i := 0; i < testBreakerConfig.Failures; i++ {
+		transport.RoundTrip(req())
+	}
+
+	time.Sleep(testBreakerConfig.OpenFor)
+	u.err = context.Canceled
+	if _, err := transport.RoundTrip(req()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled probe error = %v, want context.Canceled", err)
+	}
+	// the caller giving up says nothing about the host, the next request
+	// probes it right away
+	u.err = nil
+	atomic.StoreInt32(&u.status, http.StatusOK)
+	if resp, err := transport.RoundTrip(req()); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("request after a canceled probe = %v, want 200", err)
+	}
+}
+
+func TestBreakerNetworkErrors(t *testing.T) {
+	u := &upstream{err: errors.New("connection refused")}
+	transport, req := newBreakerTest(t, u)
+
+	for i := 0; i < testBreakerConfig.Failures; i++ {
+		transport.RoundTrip(req())
+	}
+	if _, err := transport.RoundTrip(req()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("request after %d network errors error = %v, want ErrCircuitOpen", testBreakerConfig.Failures, err)
+	}
+}
+
+func TestBreakerOverHTTP(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: newTransport(http.DefaultTransport, []Option{WithBreaker(testBreakerConfig)})}
+
+	for i := 0; i < testBreakerConfig.Failures; i++ {
+		resp, err := client.Post(srv.URL, "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := client.Post(srv.URL, "text/plain", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("request after %d failures error = %v, want ErrCircuitOpen", testBreakerConfig.Failures, err)
+	}
+	if calls != int32(testBreakerConfig.Failures) {
+		t.Errorf("upstream got %d requests, want %d", calls, testBreakerConfig.Failures)
+	}
+}
+
+func TestBreakerConfigPerClient(t *testing.T) {
+	u := &upstream{status: http.StatusInternalServerError}
+	transport, req := newBreakerTest(t, u)
+	lenient := BreakerConfig{Failures: testBreakerConfig.Failures * 2, OpenFor: testBreakerConfig.OpenFor}
+	breakers.Delete(breakerKey{req().URL.Host, lenient})
+	lenientTransport := newBreakerTransport(u, lenient)
+
+	for i := 0; i < testBreakerConfig.Failures; i++ {
+		transport.RoundTrip(req())
+	}
+	if _, err := transport.RoundTrip(req()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("request after %d failures error = %v, want ErrCircuitOpen", testBreakerConfig.Failures, err)
+	}
+	// a client configured otherwise counts failures of its own
+	for i := 0; i < lenient.Failures; i++ {
+		if _, err := lenientTransport.RoundTrip(req()); err != nil {
+			t.Fatalf("lenient request %d error = %v, want the 500 passed on", i, err)
+		}
+	}
+	if _, err := lenientTransport.RoundTrip(req()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("lenient request after %d failures error = %v, want ErrCircuitOpen", lenient.Failures, err)
+	}
+}
@@ -1,11 +1,8 @@
 package trace
 
 import (
-	"fmt"
 	"net/http"
 	"time"
-
-	"github.com/getsentry/sentry-go"
 )
 
 type tracingTransport struct {
@@ -16,34 +13,92 @@ func newTracingTransport(roundTripper http.RoundTripper) *tracingTransport {
 	return &tracingTransport{RoundTripper: roundTripper}
 }
 
+// RoundTrip records the request as a span, whose trace is propagated to the
+// upstream, and in the client metrics.
 func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	opName := fmt.Sprintf("HTTP %s %s", req.Method, req.URL.String())
-	span := sentry.StartSpan(req.Context(), opName, sentry.TransactionName(req.URL.Path))
-	defer span.Finish()
-
-	span.SetTag("url", req.URL.String())
-	if span.Data == nil {
-		span.Data = make(map[string]interface{})
-	}
+	start := time.Now()
+	ctx, span := Start(req.Context(), spanName(req))
+	defer span.End()
+	span.SetAttribute("url", DefaultRedactor.URL(req.URL))
 
+	// retries are recorded as children of this span
+	req = req.Clone(ctx)
+	current().Inject(ctx, req.Header)
 	response, err := t.RoundTripper.RoundTrip(req)
 
+	status := 0
 	if response != nil {
-		span.Data["http_code"] = response.StatusCode
+		status = response.StatusCode
+		span.SetHTTPStatus(status)
 	}
-
+	if err != nil {
+		span.SetError(err)
+	}
+	recordClient(ctx, req.Method, req.URL.Host, status, start)
 	return response, err
 }
 
-func WrapWithTrace(client *http.Client) *http.Client {
-	client.Transport = newTracingTransport(client.Transport)
+// Option configures the transport of a traced client.
+type Option func(*options)
+
+type options struct {
+	rateLimited func(*http.Response) bool
+	breaker     BreakerConfig
+	limiters    []*Limiter
+	maxWait     time.Duration
+}
+
+// RetryWhen also retries responses fn reports as rate limited, for upstreams
+// that do not answer with a 429. fn may read the body but must replace it.
+func RetryWhen(fn func(*http.Response) bool) Option {
+	return func(o *options) {
+		o.rateLimited = fn
+	}
+}
+
+// WithBreaker configures the circuit breaker of the hosts the client calls.
+func WithBreaker(config BreakerConfig) Option {
+	return func(o *options) {
+		o.breaker = config
+	}
+}
+
+// WithLimiter queues requests until all the limiters let them through, and
+// fails them with ErrRateLimited when that takes longer than maxWait.
+func WithLimiter(maxWait time.Duration, limiters ...*Limiter) Option {
+	return func(o *options) {
+		o.limiters = limiters
+		o.maxWait = maxWait
+	}
+}
+
+// newTransport traces requests, queues them through the limiters, fails fast
+// on hosts that are down and retries those that were rate limited or failed
+// upstream. A request takes a single token and is counted once by the breaker
+// however many times it was retried.
+func newTransport(roundTripper http.RoundTripper, opts []Option) http.RoundTripper {
+	o := options{breaker: DefaultBreakerConfig}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	transport := newBreakerTransport(newRetryTransport(roundTripper, o.rateLimited), o.breaker)
+	return newTracingTransport(newLimitTransport(transport, o.limiters, o.maxWait))
+}
+
+// WrapWithTrace wraps the client's transport, see newTransport.
+func WrapWithTrace(client *http.Client, opts ...Option) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = newTransport(transport, opts)
 	return client
 }
 
-func DefaultTracedClient() *http.Client {
+func DefaultTracedClient(opts ...Option) *http.Client {
 	c := &http.Client{
 		Timeout:   1 * time.Minute,
-		Transport: newTracingTransport(http.DefaultTransport),
+		Transport: newTransport(http.DefaultTransport, opts),
 	}
 	return c
 }
@@ -0,0 +1,97 @@
+package trace
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MinhPhu0304/spotify/apperr"
+)
+
+// ErrRateLimited is returned when a request would have to queue longer than
+// the wait budget of its client.
+var ErrRateLimited = apperr.Define(apperr.ErrUpstreamRateLimited, "too many requests queued, try again later")
+
+// Limiter is a token bucket letting rate requests per second through, in
+// bursts of up to burst requests.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before it is available.
+// Tokens go negative so queued requests are let through in order.
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel gives back a token taken by reserve that was not used.
+func (l *Limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+1)
+}
+
+// limitTransport queues requests until every limiter lets them through.
+type limitTransport struct {
+	http.RoundTripper
+	limiters []*Limiter
+	maxWait  time.Duration
+}
+
+func newLimitTransport(roundTripper http.RoundTripper, limiters []*Limiter, maxWait time.Duration) http.RoundTripper {
+	if len(limiters) == 0 {
+		return roundTripper
+	}
+	return &limitTransport{RoundTripper: roundTripper, limiters: limiters, maxWait: maxWait}
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, l := range t.limiters {
+		if d := l.reserve(now); d > wait {
+			wait = d
+		}
+	}
+	if span := SpanFromContext(req.Context()); span != nil {
+		span.SetAttribute("queue_wait_ms", wait.Milliseconds())
+	}
+	if wait > t.maxWait || !canWait(req.Context(), wait) {
+		t.cancel()
+		return nil, ErrRateLimited
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			t.cancel()
+			return nil, req.Context().Err()
+		}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func (t *limitTransport) cancel() {
+	for _, l := range t.limiters {
+		l.cancel()
+	}
+}
@@ -0,0 +1,126 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	l := NewLimiter(10, 2)
+	now := l.last
+
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := l.reserve(now); got != want {
+			t.Errorf("reserve %d = %v, want %v", i, got, want)
+		}
+	}
+	// the last reservation is given back, the next one takes its place
+	l.cancel()
+	if got := l.reserve(now); got != 200*time.Millisecond {
+		t.Errorf("reserve after cancel = %v, want 200ms", got)
+	}
+	// a second later the queue has drained and the bucket refilled
+	if got := l.reserve(now.Add(time.Second)); got != 0 {
+		t.Errorf("reserve a second later = %v, want 0", got)
+	}
+}
+
+func TestLimiterCancelCapsAtBurst(t *testing.T) {
+	l := NewLimiter(1, 2)
+	l.cancel()
+	if l.tokens != 2 {
+		t.Errorf("tokens after cancel on a full bucket = %v, want 2", l.tokens)
+	}
+}
+
+// newLimitedServer returns a client queued through limiter and the number of
+// requests that reached the server.
+func newLimitedServer(t *testing.T, maxWait time.Duration, limiter *Limiter) (*http.Client, string, *int32) {
+	t.Helper()
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	t.Cleanup(srv.Close)
+	client := &http.Client{Transport: newTransport(http.DefaultTransport, []Option{WithLimiter(maxWait, limiter)})}
+	return client, srv.URL, &hits
+}
+
+func doGet(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestLimitTransportQueues(t *testing.T) {
+	client, url, hits := newLimitedServer(t, time.Second, NewLimiter(20, 1))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := doGet(context.Background(), client, url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second and third requests wait 50ms each for a token
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 requests at 20/s took %v, want at least 100ms", elapsed)
+	}
+	if *hits != 3 {
+		t.Errorf("server got %d requests, want 3", *hits)
+	}
+}
+
+func TestLimitTransportMaxWait(t *testing.T) {
+	limiter := NewLimiter(1, 1)
+	client, url, hits := newLimitedServer(t, 50*time.Millisecond, limiter)
+
+	if _, err := doGet(context.Background(), client, url); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err := doGet(context.Background(), client, url)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("request past the wait budget error = %v, want ErrRateLimited", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("rate limited request took %v, want it to fail right away", elapsed)
+	}
+	if *hits != 1 {
+		t.Errorf("server got %d requests, want 1", *hits)
+	}
+	// the refused request gave its token back
+	if wait := limiter.reserve(time.Now()); wait > time.Second {
+		t.Errorf("next reservation waits %v, want at most 1s", wait)
+	}
+}
+
+func TestLimitTransportDeadline(t *testing.T) {
+	client, url, hits := newLimitedServer(t, 5*time.Second, NewLimiter(1, 1))
+
+	if _, err := doGet(context.Background(), client, url); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := doGet(ctx, client, url); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("request whose deadline is before its token error = %v, want ErrRateLimited", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("request took %v, want it to fail without waiting", elapsed)
+	}
+	if *hits != 1 {
+		t.Errorf("server got %d requests, want 1", *hits)
+	}
+}
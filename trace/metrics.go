@@ -0,0 +1,71 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// The HTTP metrics are recorded with the global meter provider, set up by
+// OTLP. They are dropped when it was not.
+var (
+	metricsOnce    sync.Once
+	serverDuration instrument.Float64Histogram
+	clientDuration instrument.Float64Histogram
+)
+
+func initMetrics() {
+	meter := global.Meter(instrumentationName)
+	var err error
+	serverDuration, err = meter.Float64Histogram("http.server.duration",
+		instrument.WithUnit("ms"),
+		instrument.WithDescription("Duration of the incoming requests"))
+	if err != nil {
+		serverDuration = nil
+	}
+	clientDuration, err = meter.Float64Histogram("http.client.duration",
+		instrument.WithUnit("ms"),
+		instrument.WithDescription("Duration of the requests made to upstreams"))
+	if err != nil {
+		clientDuration = nil
+	}
+}
+
+// RecordServer records an incoming request that was answered with status.
+func RecordServer(ctx context.Context, method string, route string, status int, start time.Time) {
+	metricsOnce.Do(initMetrics)
+	if serverDuration == nil {
+		return
+	}
+	serverDuration.Record(ctx, milliseconds(time.Since(start)),
+		semconv.HTTPMethodKey.String(method),
+		semconv.HTTPRouteKey.String(route),
+		semconv.HTTPStatusCodeKey.Int(status),
+	)
+}
+
+// recordClient records a request made to host, status is 0 when it failed
+// without a response.
+func recordClient(ctx context.Context, method string, host string, status int, start time.Time) {
+	metricsOnce.Do(initMetrics)
+	if clientDuration == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		semconv.HTTPMethodKey.String(method),
+		semconv.NetPeerNameKey.String(host),
+	}
+	if status != 0 {
+		attrs = append(attrs, semconv.HTTPStatusCodeKey.Int(status))
+	}
+	clientDuration.Record(ctx, milliseconds(time.Since(start)), attrs...)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
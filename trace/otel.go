@@ -0,0 +1,148 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/MinhPhu0304/spotify/trace"
+
+// OTLPConfig configures the export to an OpenTelemetry collector. The
+// collector and the exporters are set up with the standard OTEL_EXPORTER_OTLP_*
+// environment variables.
+type OTLPConfig struct {
+	// ServiceName is overridden by OTEL_SERVICE_NAME.
+	ServiceName string
+	// Sync exports every span as it ends, for runtimes such as Lambda that
+	// freeze the process between requests.
+	Sync bool
+}
+
+// OTLP records spans with OpenTelemetry, propagated with W3C traceparent
+// headers, and sets up the export of spans and of the HTTP metrics over OTLP.
+// shutdown flushes what was not exported yet.
+func OTLP(ctx context.Context, config OTLPConfig) (t Tracer, shutdown func(context.Context) error, err error) {
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName(config.ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create otel resource")
+	}
+	traceExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create otlp trace exporter")
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create otlp metric exporter")
+	}
+
+	spanProcessor := sdktrace.NewBatchSpanProcessor(traceExporter)
+	if config.Sync {
+		spanProcessor = sdktrace.NewSimpleSpanProcessor(traceExporter)
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithResource(res), sdktrace.WithSpanProcessor(spanProcessor))
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithResource(res), sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+	global.SetMeterProvider(meterProvider)
+
+	shutdown = func(ctx context.Context) error {
+		errTrace := tracerProvider.Shutdown(ctx)
+		errMetric := meterProvider.Shutdown(ctx)
+		if errTrace != nil {
+			return errTrace
+		}
+		return errMetric
+	}
+	return &otelTracer{
+		tracer:     tracerProvider.Tracer(instrumentationName),
+		propagator: propagation.TraceContext{},
+	}, shutdown, nil
+}
+
+type otelTracer struct {
+	tracer     oteltrace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func (t *otelTracer) StartServer(r *http.Request, name string) (context.Context, Span) {
+	ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := t.tracer.Start(ctx, name,
+		oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(semconv.HTTPMethodKey.String(r.Method)),
+	)
+	return ctx, &otelSpan{span: span, server: true}
+}
+
+func (t *otelTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, &otelSpan{span: span}
+}
+
+func (t *otelTracer) Inject(ctx context.Context, header http.Header) {
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+type otelSpan struct {
+	span   oteltrace.Span
+	server bool
+}
+
+func (s *otelSpan) SetName(name string) {
+	s.span.SetName(name)
+}
+
+func (s *otelSpan) SetAttribute(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, DefaultRedactor.String(v)))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case float64:
+		s.span.SetAttributes(attribute.Float64(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	}
+}
+
+// SetHTTPStatus marks server spans failed on 5xx only, as 4xx are the
+// client's fault, and client spans on any error status.
+func (s *otelSpan) SetHTTPStatus(code int) {
+	s.span.SetAttributes(semconv.HTTPStatusCodeKey.Int(code))
+	if code >= http.StatusInternalServerError || (!s.server && code >= http.StatusBadRequest) {
+		s.span.SetStatus(codes.Error, http.StatusText(code))
+	}
+}
+
+// SetError records err as an exception event. Its message is redacted first,
+// errors of HTTP clients quote the request URL and its credentials.
+func (s *otelSpan) SetError(err error) {
+	msg := DefaultRedactor.String(err.Error())
+	s.span.AddEvent(semconv.ExceptionEventName, oteltrace.WithAttributes(
+		semconv.ExceptionTypeKey.String(fmt.Sprintf("%T", err)),
+		semconv.ExceptionMessageKey.String(msg),
+	))
+	s.span.SetStatus(codes.Error, msg)
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
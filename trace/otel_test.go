@@ -0,0 +1,52 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOtelSpanRedacts(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := &otelTracer{tracer: provider.Tracer(instrumentationName)}
+
+	const secret = "0123456789abcdef0123456789abcdef"
+	_, span := tracer.Start(context.Background(), "lastfm")
+	span.SetAttribute("url", "https://ws.audioscrobbler.com/2.0/?method=artist.getinfo&api_key="+secret)
+	span.SetError(&url.Error{
+		Op:  "Get",
+		URL: "https://ws.audioscrobbler.com/2.0/?method=artist.getinfo&api_key=" + secret,
+		Err: errors.New("net/http: TLS handshake timeout"),
+	})
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	recorded := spans[0]
+	var values []string
+	for _, attr := range recorded.Attributes() {
+		values = append(values, attr.Value.Emit())
+	}
+	for _, event := range recorded.Events() {
+		for _, attr := range event.Attributes {
+			values = append(values, attr.Value.Emit())
+		}
+	}
+	values = append(values, recorded.Status().Description)
+	for _, v := range values {
+		if strings.Contains(v, secret) {
+			t.Errorf("span recorded the API key: %q", v)
+		}
+	}
+	if len(recorded.Events()) != 1 || !strings.Contains(recorded.Status().Description, "TLS handshake timeout") {
+		t.Errorf("error not recorded: events %v, status %v", recorded.Events(), recorded.Status())
+	}
+}
@@ -0,0 +1,169 @@
+package trace
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+const redacted = "[REDACTED]"
+
+// RedactConfig lists what is scrubbed on top of the defaults.
+type RedactConfig struct {
+	// Params are query parameters, and JSON fields, whose value is secret.
+	Params []string
+	// Headers are request headers whose value is secret.
+	Headers []string
+	// Patterns match secrets wherever they appear.
+	Patterns []*regexp.Regexp
+}
+
+var defaultRedactConfig = RedactConfig{
+	Params:  []string{"api_key", "token", "code", "state", "access_token", "refresh_token", "client_secret", "code_verifier", "session"},
+	Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	Patterns: []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\b(bearer|basic)\s+[a-z0-9\-._~+/]+=*`),
+		// last.fm API keys and secrets
+		regexp.MustCompile(`\b[0-9a-fA-F]{32}\b`),
+	},
+}
+
+// Redactor scrubs secrets from what is sent to Sentry.
+type Redactor struct {
+	params   *regexp.Regexp
+	fields   *regexp.Regexp
+	headers  map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// DefaultRedactor is used by traced clients and the Sentry hooks. It can be
+// replaced at startup, before any request is made.
+var DefaultRedactor = NewRedactor(RedactConfig{})
+
+// NewRedactor scrubs the defaults and what config adds to them.
+func NewRedactor(config RedactConfig) *Redactor {
+	params := append(append([]string{}, defaultRedactConfig.Params...), config.Params...)
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = regexp.QuoteMeta(p)
+	}
+	alternatives := strings.Join(names, "|")
+	headers := make(map[string]bool)
+	for _, h := range append(append([]string{}, defaultRedactConfig.Headers...), config.Headers...) {
+		headers[strings.ToLower(h)] = true
+	}
+	return &Redactor{
+		params:   regexp.MustCompile(`(?i)\b(` + alternatives + `)=[^&\s"'#]+`),
+		fields:   regexp.MustCompile(`(?i)"(` + alternatives + `)"\s*:\s*"[^"]*"`),
+		headers:  headers,
+		patterns: append(append([]*regexp.Regexp{}, defaultRedactConfig.Patterns...), config.Patterns...),
+	}
+}
+
+// String scrubs secret parameters and known token patterns from s.
+func (r *Redactor) String(s string) string {
+	s = r.params.ReplaceAllString(s, "${1}="+redacted)
+	s = r.fields.ReplaceAllString(s, `"${1}":"`+redacted+`"`)
+	for _, p := range r.patterns {
+		s = p.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// URL returns u with the values of secret parameters scrubbed.
+func (r *Redactor) URL(u *url.URL) string {
+	return r.String(u.String())
+}
+
+// Event scrubs an event before it is sent, it is meant for both
+// BeforeSend and BeforeSendTransaction.
+func (r *Redactor) Event(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+	event.Message = r.String(event.Message)
+	event.Transaction = TemplatePath(event.Transaction)
+	for i := range event.Exception {
+		event.Exception[i].Value = r.String(event.Exception[i].Value)
+	}
+	for _, b := range event.Breadcrumbs {
+		r.Breadcrumb(b, nil)
+	}
+	r.tags(event.Tags)
+	r.data(event.Extra)
+	if req := event.Request; req != nil {
+		req.URL = r.String(req.URL)
+		req.QueryString = r.String(req.QueryString)
+		req.Data = r.String(req.Data)
+		if req.Cookies != "" {
+			req.Cookies = redacted
+		}
+		for name := range req.Headers {
+			if r.headers[strings.ToLower(name)] {
+				req.Headers[name] = redacted
+			}
+		}
+	}
+	for _, span := range event.Spans {
+		span.Description = r.String(span.Description)
+		r.tags(span.Tags)
+		r.data(span.Data)
+	}
+	return event
+}
+
+// Breadcrumb scrubs a breadcrumb before it is recorded, it is meant for
+// BeforeBreadcrumb.
+func (r *Redactor) Breadcrumb(b *sentry.Breadcrumb, _ *sentry.BreadcrumbHint) *sentry.Breadcrumb {
+	b.Message = r.String(b.Message)
+	r.data(b.Data)
+	return b
+}
+
+func (r *Redactor) tags(tags map[string]string) {
+	for k, v := range tags {
+		tags[k] = r.String(v)
+	}
+}
+
+func (r *Redactor) data(data map[string]interface{}) {
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			data[k] = r.String(s)
+		}
+	}
+}
+
+var idSegments = []*regexp.Regexp{
+	// Spotify IDs
+	regexp.MustCompile(`^[0-9A-Za-z]{22}$`),
+	// MusicBrainz IDs
+	regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+	// ISRCs
+	regexp.MustCompile(`^[A-Z]{2}[0-9A-Z]{3}[0-9]{7}$`),
+}
+
+// TemplatePath replaces the IDs in a path with {id}, so spans and
+// transactions of the same endpoint are named alike.
+func TemplatePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		for _, id := range idSegments {
+			if id.MatchString(seg) {
+				segments[i] = "{id}"
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// spanName names the span of an outgoing request after its templated path,
+// along with the method of RPC style APIs such as last.fm's.
+func spanName(req *http.Request) string {
+	name := "HTTP " + req.Method + " " + req.URL.Host + TemplatePath(req.URL.Path)
+	if method := req.URL.Query().Get("method"); method != "" {
+		name += " " + method
+	}
+	return name
+}
@@ -0,0 +1,202 @@
+package trace
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+func TestRedactorString(t *testing.T) {
+	r := NewRedactor(RedactConfig{})
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			"last.fm api key",
+			"Get \"https://ws.audioscrobbler.com/2.0/?api_key=secretkey&method=artist.getinfo\": timeout",
+			"Get \"https://ws.audioscrobbler.com/2.0/?api_key=[REDACTED]&method=artist.getinfo\": timeout",
+		},
+		{
+			"oauth callback",
+			"/callback?code=AQBx-123_abc&state=Zm9vYmFy",
+			"/callback?code=[REDACTED]&state=[REDACTED]",
+		},
+		{
+			"dashboard redirect",
+			"https://dashboard.example.com/callback#session=AbC-123_x&expr=2023-06-01",
+			"https://dashboard.example.com/callback#session=[REDACTED]&expr=2023-06-01",
+		},
+		{
+			"refresh token form",
+			"grant_type=refresh_token&refresh_token=AQD-xyz&client_id=abc",
+			"grant_type=refresh_token&refresh_token=[REDACTED]&client_id=abc",
+		},
+		{
+			"parameter names are case insensitive",
+			"?API_KEY=secretkey",
+			"?API_KEY=[REDACTED]",
+		},
+		{
+			"bearer header",
+			"Authorization: Bearer BQDx-y_z.123",
+			"Authorization: [REDACTED]",
+		},
+		{
+			"basic header",
+			"authorization: basic dXNlcjpwYXNz==",
+			"authorization: [REDACTED]",
+		},
+		{
+			"json access token",
+			`{"access_token":"BQDxyz","token_type":"Bearer","expires_in":3600}`,
+			`{"access_token":"[REDACTED]","token_type":"Bearer","expires_in":3600}`,
+		},
+		{
+			"json fields with spaces",
+			`{"refresh_token" : "AQDxyz", "scope": "user-top-read"}`,
+			`{"refresh_token":"[REDACTED]", "scope": "user-top-read"}`,
+		},
+		{
+			"bare last.fm key",
+			"invalid key 0123456789abcdef0123456789abcdef",
+			"invalid key [REDACTED]",
+		},
+		{
+			"harmless text",
+			"GET https://api.spotify.com/v1/me/top/artists?limit=20&time_range=short_term: 429 Too Many Requests",
+			"GET https://api.spotify.com/v1/me/top/artists?limit=20&time_range=short_term: 429 Too Many Requests",
+		},
+		{
+			"words containing a parameter name",
+			"postcode=1010 and barcode=123",
+			"postcode=1010 and barcode=123",
+		},
+		{
+			"empty",
+			"",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.String(tt.in); got != tt.want {
+				t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactorConfig(t *testing.T) {
+	r := NewRedactor(RedactConfig{
+		Params:   []string{"session"},
+		Patterns: []*regexp.Regexp{regexp.MustCompile(`sk_[a-z0-9]+`)},
+	})
+	in := "?session=abc&api_key=def sk_live123"
+	want := "?session=[REDACTED]&api_key=[REDACTED] [REDACTED]"
+	if got := r.String(in); got != want {
+		t.Errorf("String(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestRedactorURL(t *testing.T) {
+	u, _ := url.Parse("https://accounts.spotify.com/api/token?code=abc&redirect_uri=https%3A%2F%2Fexample.com")
+	want := "https://accounts.spotify.com/api/token?code=[REDACTED]&redirect_uri=https%3A%2F%2Fexample.com"
+	if got := NewRedactor(RedactConfig{}).URL(u); got != want {
+		t.Errorf("URL() = %q, want %q", got, want)
+	}
+}
+
+func TestRedactorEvent(t *testing.T) {
+	r := NewRedactor(RedactConfig{Headers: []string{"X-Session-Id"}})
+	event := &sentry.Event{
+		Message:     "failed: api_key=secret",
+		Transaction: "GET /artist/4Z8W4fKeB5YxbusRsdQVPb",
+		Exception:   []sentry.Exception{{Value: "Authorization: Bearer abc"}},
+		Tags:        map[string]string{"url": "/callback?state=abc"},
+		Extra:       map[string]interface{}{"body": `{"access_token":"abc"}`, "count": 3},
+		Breadcrumbs: []*sentry.Breadcrumb{{Message: "GET ?token=abc"}},
+		Request: &sentry.Request{
+			URL:         "https://api.example.com/callback?code=abc",
+			QueryString: "code=abc&state=def",
+			Cookies:     "session=abc",
+			Headers: map[string]string{
+				"Authorization": "Bearer abc",
+				"X-Session-Id":  "abc",
+				"Accept":        "application/json",
+			},
+		},
+		Spans: []*sentry.Span{{Description: "GET ?api_key=abc", Tags: map[string]string{"url": "?code=abc"}}},
+	}
+	r.Event(event, nil)
+
+	checks := map[string]string{
+		"message":        event.Message,
+		"exception":      event.Exception[0].Value,
+		"tag":            event.Tags["url"],
+		"extra":          event.Extra["body"].(string),
+		"breadcrumb":     event.Breadcrumbs[0].Message,
+		"request url":    event.Request.URL,
+		"query string":   event.Request.QueryString,
+		"cookies":        event.Request.Cookies,
+		"authorization":  event.Request.Headers["Authorization"],
+		"session header": event.Request.Headers["X-Session-Id"],
+		"span":           event.Spans[0].Description,
+		"span tag":       event.Spans[0].Tags["url"],
+	}
+	for name, v := range checks {
+		if strings.Contains(v, "abc") || strings.Contains(v, "secret") || strings.Contains(v, "def") {
+			t.Errorf("%s not scrubbed: %q", name, v)
+		}
+	}
+	if event.Request.Headers["Accept"] != "application/json" {
+		t.Errorf("Accept header = %q, want it untouched", event.Request.Headers["Accept"])
+	}
+	if event.Extra["count"] != 3 {
+		t.Errorf("extra count = %v, want it untouched", event.Extra["count"])
+	}
+	if event.Transaction != "GET /artist/{id}" {
+		t.Errorf("transaction = %q, want GET /artist/{id}", event.Transaction)
+	}
+}
+
+func TestTemplatePath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/v1/artists/4Z8W4fKeB5YxbusRsdQVPb/top-tracks", "/v1/artists/{id}/top-tracks"},
+		{"/ws/2/artist/a74b1b7f-71a5-4011-9441-d0b5e4122711", "/ws/2/artist/{id}"},
+		{"/ws/2/isrc/GBAYE9700106", "/ws/2/isrc/{id}"},
+		{"/v1/me/top/artists", "/v1/me/top/artists"},
+		{"/v1/recommendations/available-genre-seeds", "/v1/recommendations/available-genre-seeds"},
+		{"/2.0/", "/2.0/"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := TemplatePath(tt.in); got != tt.want {
+			t.Errorf("TemplatePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSpanName(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.spotify.com/v1/tracks/6LgJvl0Xdtc73RJ1mmpotq", "HTTP GET api.spotify.com/v1/tracks/{id}"},
+		{"https://ws.audioscrobbler.com/2.0/?method=artist.getinfo&api_key=abc", "HTTP GET ws.audioscrobbler.com/2.0/ artist.getinfo"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		if got := spanName(req); got != tt.want {
+			t.Errorf("spanName(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxRetries = 3
+	// baseBackoff is the delay before the first retry, doubled for every
+	// following one up to maxBackoff.
+	baseBackoff = 200 * time.Millisecond
+	maxBackoff  = 5 * time.Second
+	// maxRetryAfter is the longest Retry-After that is waited for, the
+	// response is passed on when the upstream asks for more.
+	maxRetryAfter = 10 * time.Second
+)
+
+// retryTransport retries rate limited requests once the upstream allows it and
+// idempotent requests that failed with a 5xx, with jittered exponential
+// backoff. A retry is never waited for past the request's deadline.
+type retryTransport struct {
+	http.RoundTripper
+	rateLimited func(*http.Response) bool
+}
+
+func newRetryTransport(roundTripper http.RoundTripper, rateLimited func(*http.Response) bool) *retryTransport {
+	return &retryTransport{RoundTripper: roundTripper, rateLimited: rateLimited}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		wait, ok := t.retryDelay(req, resp, err, attempt)
+		if !ok || !canWait(req.Context(), wait) {
+			break
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		resp, err = t.retry(req, attempt, wait)
+	}
+	return resp, err
+}
+
+// retry sends req again after wait, recorded as a child span of the request.
+func (t *retryTransport) retry(req *http.Request, attempt int, wait time.Duration) (*http.Response, error) {
+	ctx, span := Start(req.Context(), fmt.Sprintf("retry %d of %s %s", attempt, req.Method, TemplatePath(req.URL.Path)))
+	span.SetAttribute("attempt", attempt)
+	span.SetAttribute("wait_ms", wait.Milliseconds())
+	defer span.End()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-req.Context().Done():
+		span.SetError(req.Context().Err())
+		return nil, req.Context().Err()
+	}
+
+	retryReq := req.Clone(ctx)
+	if req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retryReq.Body = body
+	}
+	resp, err := t.RoundTripper.RoundTrip(retryReq)
+	if resp != nil {
+		span.SetHTTPStatus(resp.StatusCode)
+	}
+	return resp, err
+}
+
+// retryDelay tells whether the outcome of a request is worth retrying and how
+// long to wait before doing so.
+func (t *retryTransport) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil || (req.Body != nil && req.GetBody == nil) {
+		return 0, false
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, t.rateLimited != nil && t.rateLimited(resp):
+		if wait, ok := retryAfter(resp); ok {
+			return wait, wait <= maxRetryAfter
+		}
+		return backoff(attempt), true
+	case resp.StatusCode >= http.StatusInternalServerError && isIdempotent(req):
+		if wait, ok := retryAfter(resp); ok {
+			return wait, wait <= maxRetryAfter
+		}
+		return backoff(attempt), true
+	}
+	return 0, false
+}
+
+func isIdempotent(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// retryAfter parses the Retry-After header, given in seconds or as a date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoff is the exponential delay before the attempt, jittered between half
+// and all of it so clients rate limited together do not retry together.
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << (attempt - 1)
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// canWait tells whether waiting still leaves time before the deadline of ctx.
+func canWait(ctx context.Context, wait time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > wait
+}
@@ -0,0 +1,231 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 8; attempt++ {
+		d := baseBackoff << (attempt - 1)
+		if d > maxBackoff {
+			d = maxBackoff
+		}
+		for i := 0; i < 100; i++ {
+			if got := backoff(attempt); got < d/2 || got > d {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", attempt, got, d/2, d)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{"Retry-After": {tt.header}}}
+		if got, ok := retryAfter(resp); got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// dates are relative to now, and only precise to the second
+	resp := &http.Response{Header: http.Header{"Retry-After": {time.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat)}}}
+	if got, ok := retryAfter(resp); !ok || got <= 3*time.Second || got > 5*time.Second {
+		t.Errorf("retryAfter(date in 5s) = %v, %v, want about 5s", got, ok)
+	}
+}
+
+func TestCanWait(t *testing.T) {
+	if !canWait(context.Background(), time.Hour) {
+		t.Error("canWait without deadline = false, want true")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !canWait(ctx, 100*time.Millisecond) {
+		t.Error("canWait(100ms) with 1s left = false, want true")
+	}
+	if canWait(ctx, 2*time.Second) {
+		t.Error("canWait(2s) with 1s left = true, want false")
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if canWait(canceled, 0) {
+		t.Error("canWait on a canceled context = true, want false")
+	}
+}
+
+// newRetryServer answers with the statuses in turn, then with 200, and
+// returns a client retrying it and the bodies of the requests it got.
+func newRetryServer(t *testing.T, header http.Header, statuses ...int) (*http.Client, string, func() []string) {
+	t.Helper()
+	var calls int32
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		i := int(atomic.AddInt32(&calls, 1)) - 1
+		if i >= len(statuses) {
+			return
+		}
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(statuses[i])
+	}))
+	t.Cleanup(srv.Close)
+	client := &http.Client{Transport: newTransport(http.DefaultTransport, nil)}
+	return client, srv.URL, func() []string {
+		var got []string
+		for len(bodies) > 0 {
+			got = append(got, <-bodies)
+		}
+		return got
+	}
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	client, url, requests := newRetryServer(t, http.Header{"Retry-After": {"0"}}, http.StatusTooManyRequests)
+
+	resp, err := doGet(context.Background(), client, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(requests()) != 2 {
+		t.Errorf("got %d after %d requests, want 200 after 2", resp.StatusCode, len(requests()))
+	}
+}
+
+func TestRetryRateLimitedPost(t *testing.T) {
+	client, url, requests := newRetryServer(t, http.Header{"Retry-After": {"0"}}, http.StatusTooManyRequests)
+
+	resp, err := client.Post(url, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// a rate limited request was not processed, so it is safe to send again
+	if got := requests(); resp.StatusCode != http.StatusOK || len(got) != 2 || got[1] != "payload" {
+		t.Errorf("got %d after requests %q, want 200 after the payload was sent twice", resp.StatusCode, got)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	client, url, requests := newRetryServer(t, http.Header{"Retry-After": {"60"}}, http.StatusTooManyRequests)
+
+	start := time.Now()
+	resp, err := doGet(context.Background(), client, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || len(requests()) != 1 {
+		t.Errorf("got %d after %d requests, want the 429 passed on", resp.StatusCode, len(requests()))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v, want no wait", elapsed)
+	}
+}
+
+func TestRetryServerError(t *testing.T) {
+	client, url, requests := newRetryServer(t, nil, http.StatusBadGateway, http.StatusServiceUnavailable)
+
+	resp, err := doGet(context.Background(), client, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(requests()) != 3 {
+		t.Errorf("got %d after %d requests, want 200 after 3", resp.StatusCode, len(requests()))
+	}
+}
+
+func TestRetryServerErrorGivesUp(t *testing.T) {
+	statuses := make([]int, maxRetries+1)
+	for i := range statuses {
+		statuses[i] = http.StatusInternalServerError
+	}
+	client, url, requests := newRetryServer(t, nil, statuses...)
+
+	resp, err := doGet(context.Background(), client, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || len(requests()) != maxRetries+1 {
+		t.Errorf("got %d after %d requests, want 500 after %d", resp.StatusCode, len(requests()), maxRetries+1)
+	}
+}
+
+func TestRetryServerErrorPost(t *testing.T) {
+	client, url, requests := newRetryServer(t, nil, http.StatusInternalServerError)
+
+	resp, err := client.Post(url, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// the upstream may have processed it, a POST is not sent twice
+	if resp.StatusCode != http.StatusInternalServerError || len(requests()) != 1 {
+		t.Errorf("got %d after %d requests, want the 500 passed on", resp.StatusCode, len(requests()))
+	}
+}
+
+func TestRetryPastDeadline(t *testing.T) {
+	client, url, requests := newRetryServer(t, http.Header{"Retry-After": {"5"}}, http.StatusTooManyRequests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp, err := doGet(ctx, client, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || len(requests()) != 1 {
+		t.Errorf("got %d after %d requests, want the 429 passed on", resp.StatusCode, len(requests()))
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("request took %v, want no wait past the deadline", elapsed)
+	}
+}
+
+func TestRetryWhen(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Write([]byte(`{"error":29}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	rateLimited := func(resp *http.Response) bool {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body = io.NopCloser(strings.NewReader(string(body)))
+		return strings.Contains(string(body), `"error":29`)
+	}
+	client := &http.Client{Transport: newTransport(http.DefaultTransport, []Option{RetryWhen(rateLimited)})}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "{}" || calls != 2 {
+		t.Errorf("got %s after %d requests, want {} after 2", body, calls)
+	}
+}
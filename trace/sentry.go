@@ -0,0 +1,104 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Sentry records spans as Sentry transactions. Incoming requests are expected
+// to be wrapped by sentryhttp, whose transaction is then used as their span.
+func Sentry() Tracer {
+	return sentryTracer{}
+}
+
+type sentryTracer struct{}
+
+func (sentryTracer) StartServer(r *http.Request, name string) (context.Context, Span) {
+	if tx := sentry.TransactionFromContext(r.Context()); tx != nil {
+		// sentryhttp finishes its own transaction
+		return r.Context(), &sentrySpan{span: tx, borrowed: true}
+	}
+	span := sentry.StartSpan(r.Context(), "http.server", sentry.TransactionName(name), sentry.ContinueFromRequest(r))
+	return span.Context(), &sentrySpan{span: span}
+}
+
+func (sentryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	// the name is that of the transaction when there is no parent span
+	span := sentry.StartSpan(ctx, name, sentry.TransactionName(name))
+	return span.Context(), &sentrySpan{span: span}
+}
+
+// Inject is a no-op, upstreams do not take part in Sentry traces.
+func (sentryTracer) Inject(context.Context, http.Header) {}
+
+type sentrySpan struct {
+	span     *sentry.Span
+	borrowed bool
+}
+
+func (s *sentrySpan) SetName(name string) {
+	if s.span.IsTransaction() {
+		s.span.Name = name
+	} else {
+		s.span.Description = name
+	}
+}
+
+// SetAttribute records strings as tags, which Sentry can search by, and
+// anything else as data.
+func (s *sentrySpan) SetAttribute(key string, value interface{}) {
+	if v, ok := value.(string); ok {
+		s.span.SetTag(key, v)
+		return
+	}
+	if s.span.Data == nil {
+		s.span.Data = make(map[string]interface{})
+	}
+	s.span.Data[key] = value
+}
+
+func (s *sentrySpan) SetHTTPStatus(code int) {
+	s.SetAttribute("http_code", code)
+	s.span.Status = sentrySpanStatus(code)
+}
+
+func sentrySpanStatus(code int) sentry.SpanStatus {
+	switch {
+	case code < http.StatusBadRequest:
+		return sentry.SpanStatusOK
+	case code == http.StatusUnauthorized:
+		return sentry.SpanStatusUnauthenticated
+	case code == http.StatusForbidden:
+		return sentry.SpanStatusPermissionDenied
+	case code == http.StatusNotFound:
+		return sentry.SpanStatusNotFound
+	case code == http.StatusTooManyRequests:
+		return sentry.SpanStatusResourceExhausted
+	case code == http.StatusServiceUnavailable:
+		return sentry.SpanStatusUnavailable
+	case code == http.StatusGatewayTimeout:
+		return sentry.SpanStatusDeadlineExceeded
+	case code >= http.StatusInternalServerError:
+		return sentry.SpanStatusInternalError
+	}
+	return sentry.SpanStatusInvalidArgument
+}
+
+func (s *sentrySpan) SetError(err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.span.Status = sentry.SpanStatusDeadlineExceeded
+	} else {
+		s.span.Status = sentry.SpanStatusInternalError
+	}
+	s.SetAttribute("error", fmt.Sprint(err))
+}
+
+func (s *sentrySpan) End() {
+	if !s.borrowed {
+		s.span.Finish()
+	}
+}
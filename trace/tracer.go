@@ -0,0 +1,133 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Tracer records spans to a tracing backend, see SetTracer.
+type Tracer interface {
+	// StartServer starts the span of an incoming request, continuing the
+	// trace its headers carry.
+	StartServer(r *http.Request, name string) (context.Context, Span)
+	// Start starts a child of the span in ctx.
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Inject writes the trace of ctx to the headers of an outgoing request.
+	Inject(ctx context.Context, header http.Header)
+}
+
+// Span is a timed operation of a trace.
+type Span interface {
+	SetName(name string)
+	// SetAttribute records a string, number or bool on the span.
+	SetAttribute(key string, value interface{})
+	SetHTTPStatus(code int)
+	SetError(err error)
+	End()
+}
+
+var (
+	tracerMu sync.RWMutex
+	tracer   Tracer = Multi()
+)
+
+// SetTracer selects where spans are recorded, nowhere by default. It is meant
+// to be called once at startup.
+func SetTracer(t Tracer) {
+	tracerMu.Lock()
+	defer tracerMu.Unlock()
+	tracer = t
+}
+
+func current() Tracer {
+	tracerMu.RLock()
+	defer tracerMu.RUnlock()
+	return tracer
+}
+
+// StartServer starts the span of an incoming request with the selected tracer.
+func StartServer(r *http.Request, name string) (context.Context, Span) {
+	ctx, span := current().StartServer(r, name)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start starts a child of the span in ctx with the selected tracer.
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := current().Start(ctx, name)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span started last in ctx, nil when there is
+// none.
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// Multi records spans with every tracer.
+func Multi(tracers ...Tracer) Tracer {
+	return multiTracer(tracers)
+}
+
+type multiTracer []Tracer
+
+func (m multiTracer) StartServer(r *http.Request, name string) (context.Context, Span) {
+	spans := make(multiSpan, 0, len(m))
+	for _, t := range m {
+		ctx, span := t.StartServer(r, name)
+		r = r.WithContext(ctx)
+		spans = append(spans, span)
+	}
+	return r.Context(), spans
+}
+
+func (m multiTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	spans := make(multiSpan, 0, len(m))
+	for _, t := range m {
+		var span Span
+		ctx, span = t.Start(ctx, name)
+		spans = append(spans, span)
+	}
+	return ctx, spans
+}
+
+func (m multiTracer) Inject(ctx context.Context, header http.Header) {
+	for _, t := range m {
+		t.Inject(ctx, header)
+	}
+}
+
+type multiSpan []Span
+
+func (m multiSpan) SetName(name string) {
+	for _, s := range m {
+		s.SetName(name)
+	}
+}
+
+func (m multiSpan) SetAttribute(key string, value interface{}) {
+	for _, s := range m {
+		s.SetAttribute(key, value)
+	}
+}
+
+func (m multiSpan) SetHTTPStatus(code int) {
+	for _, s := range m {
+		s.SetHTTPStatus(code)
+	}
+}
+
+func (m multiSpan) SetError(err error) {
+	for _, s := range m {
+		s.SetError(err)
+	}
+}
+
+func (m multiSpan) End() {
+	for i := len(m) - 1; i >= 0; i-- {
+		m[i].End()
+	}
+}
@@ -6,5 +6,21 @@ type ArtistInfo struct {
 	Artirst       spotify.FullArtist               `json:"artist"`
 	TopTracks     []spotify.FullTrack              `json:"topTracks"`
 	AudioFeatures map[string]spotify.AudioFeatures `json:"trackFeatures"`
-	Bio           []string                         `json:"bio"`
+	// Bio holds the paragraphs of the artist's last.fm biography.
+	Bio            []string  `json:"bio"`
+	BioSummary     string    `json:"bioSummary,omitempty"`
+	BioLinks       []BioLink `json:"bioLinks,omitempty"`
+	BioAttribution string    `json:"bioAttribution,omitempty"`
+	// BioLang is the language of Bio, English when there is no translation in
+	// the language asked for.
+	BioLang string `json:"bioLang,omitempty"`
+	// Degraded is set when the bio could not be fetched in time, the artist
+	// is then returned without it.
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// BioLink is a link found in a last.fm biography, mostly to related artists.
+type BioLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
 }
@@ -0,0 +1,33 @@
+package lastfm
+
+import "encoding/json"
+
+type AlbumTrack struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Duration is in seconds and missing for some tracks.
+	Duration json.Number `json:"duration"`
+	Artist   TrackArtist `json:"artist"`
+	Attr     struct {
+		Rank int `json:"rank"`
+	} `json:"@attr"`
+}
+
+type Album struct {
+	Name      string  `json:"name"`
+	Artist    string  `json:"artist"`
+	MBID      string  `json:"mbid"`
+	URL       string  `json:"url"`
+	Listeners string  `json:"listeners"`
+	Playcount string  `json:"playcount"`
+	Image     []Image `json:"image"`
+	Tags      Tags    `json:"tags"`
+	Tracks    struct {
+		Track List[AlbumTrack] `json:"track"`
+	} `json:"tracks"`
+	Wiki *Wiki `json:"wiki"`
+}
+
+type AlbumInfo struct {
+	Album *Album `json:"album"`
+}
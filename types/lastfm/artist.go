@@ -20,11 +20,35 @@ type Artist struct {
 	URL   string     `json:"url"`
 	Bio   *ArtistBio `json:"bio"`
 	Image []Image
-	Tags  struct {
-		Tag []Tag `json:"tag"`
-	}
+	Tags  Tags
 }
 
 type LastFMBio struct {
 	Artist *Artist `json:"artist"`
 }
+
+type SimilarArtist struct {
+	Name  string  `json:"name"`
+	MBID  string  `json:"mbid"`
+	URL   string  `json:"url"`
+	Match float64 `json:"match,string"`
+	Image []Image `json:"image"`
+}
+
+type SimilarArtists struct {
+	SimilarArtists struct {
+		Artist List[SimilarArtist] `json:"artist"`
+	} `json:"similarartists"`
+}
+
+type TopTag struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Count int    `json:"count"`
+}
+
+type TopTags struct {
+	TopTags struct {
+		Tag List[TopTag] `json:"tag"`
+	} `json:"toptags"`
+}
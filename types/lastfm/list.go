@@ -0,0 +1,55 @@
+package lastfm
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// List decodes the lists of last.fm responses, which are an object instead of
+// an array when they hold a single item and an empty string when they hold none.
+type List[T any] []T
+
+func (l *List[T]) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte(`""`)), bytes.Equal(data, []byte("null")):
+		*l = nil
+		return nil
+	case len(data) > 0 && data[0] == '{':
+		var item T
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		*l = List[T]{item}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]T)(l))
+}
+
+// Tags is the tag list nested in artists, tracks and albums.
+type Tags struct {
+	Tag List[Tag] `json:"tag"`
+}
+
+func (t *Tags) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte(`""`)) {
+		*t = Tags{}
+		return nil
+	}
+	type tags Tags
+	return json.Unmarshal(data, (*tags)(t))
+}
+
+// Wiki is the user written description of a track or an album.
+type Wiki struct {
+	Published string `json:"published"`
+	Summary   string `json:"summary"`
+	Content   string `json:"content"`
+}
+
+// Error is the envelope last.fm answers failed calls with, often along with
+// a 200 status.
+type Error struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,17 @@
+package lastfm
+
+type TagArtist struct {
+	Name  string  `json:"name"`
+	MBID  string  `json:"mbid"`
+	URL   string  `json:"url"`
+	Image []Image `json:"image"`
+	Attr  struct {
+		Rank string `json:"rank"`
+	} `json:"@attr"`
+}
+
+type TagTopArtists struct {
+	TopArtists struct {
+		Artist List[TagArtist] `json:"artist"`
+	} `json:"topartists"`
+}
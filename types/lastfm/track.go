@@ -0,0 +1,82 @@
+package lastfm
+
+import "encoding/json"
+
+// Text is how last.fm nests a named entity, e.g. {"mbid": "...", "#text": "Radiohead"}.
+type Text struct {
+	MBID string `json:"mbid"`
+	Text string `json:"#text"`
+}
+
+type ScrobbleDate struct {
+	UTS  int64  `json:"uts,string"`
+	Text string `json:"#text"`
+}
+
+// RecentTrack is a scrobble of user.getRecentTracks. The track playing right
+// now has no Date and NowPlaying set instead.
+type RecentTrack struct {
+	Name   string        `json:"name"`
+	MBID   string        `json:"mbid"`
+	URL    string        `json:"url"`
+	Artist Text          `json:"artist"`
+	Album  Text          `json:"album"`
+	Date   *ScrobbleDate `json:"date"`
+	Attr   struct {
+		NowPlaying string `json:"nowplaying"`
+	} `json:"@attr"`
+}
+
+func (t RecentTrack) IsNowPlaying() bool {
+	return t.Attr.NowPlaying == "true"
+}
+
+type RecentTracksAttr struct {
+	User       string `json:"user"`
+	Page       int    `json:"page,string"`
+	PerPage    int    `json:"perPage,string"`
+	TotalPages int    `json:"totalPages,string"`
+	Total      int    `json:"total,string"`
+}
+
+type RecentTracks struct {
+	RecentTracks struct {
+		Track List[RecentTrack] `json:"track"`
+		Attr  RecentTracksAttr  `json:"@attr"`
+	} `json:"recenttracks"`
+}
+
+type TrackArtist struct {
+	Name string `json:"name"`
+	MBID string `json:"mbid"`
+	URL  string `json:"url"`
+}
+
+type TrackAlbum struct {
+	Artist string  `json:"artist"`
+	Title  string  `json:"title"`
+	MBID   string  `json:"mbid"`
+	URL    string  `json:"url"`
+	Image  []Image `json:"image"`
+}
+
+type Track struct {
+	Name string `json:"name"`
+	MBID string `json:"mbid"`
+	URL  string `json:"url"`
+	// Duration is in milliseconds, zero when last.fm does not know it.
+	Duration  json.Number `json:"duration"`
+	Listeners string      `json:"listeners"`
+	Playcount string      `json:"playcount"`
+	Artist    TrackArtist `json:"artist"`
+	Album     *TrackAlbum `json:"album"`
+	TopTags   Tags        `json:"toptags"`
+	Wiki      *Wiki       `json:"wiki"`
+	// UserPlaycount and UserLoved are only sent when asked for a username.
+	UserPlaycount json.Number `json:"userplaycount"`
+	UserLoved     json.Number `json:"userloved"`
+}
+
+type TrackInfo struct {
+	Track *Track `json:"track"`
+}
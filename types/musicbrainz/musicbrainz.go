@@ -0,0 +1,36 @@
+package musicbrainz
+
+type Artist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Relation struct {
+	Type   string  `json:"type"`
+	Artist *Artist `json:"artist"`
+}
+
+// URL is a web resource known to MusicBrainz along with the entities it is
+// related to.
+type URL struct {
+	ID        string     `json:"id"`
+	Resource  string     `json:"resource"`
+	Relations []Relation `json:"relations"`
+}
+
+type ArtistCredit struct {
+	Name   string `json:"name"`
+	Artist Artist `json:"artist"`
+}
+
+type Recording struct {
+	ID           string         `json:"id"`
+	Title        string         `json:"title"`
+	ArtistCredit []ArtistCredit `json:"artist-credit"`
+}
+
+// ISRC lists the recordings registered under an ISRC.
+type ISRC struct {
+	ISRC       string      `json:"isrc"`
+	Recordings []Recording `json:"recordings"`
+}
@@ -0,0 +1,11 @@
+package types
+
+import "time"
+
+type OAuthState struct {
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"createdAt"`
+	// CodeVerifier is only set for PKCE logins.
+	CodeVerifier string `json:"codeVerifier,omitempty"`
+	Consumed     bool   `json:"consumed"`
+}
@@ -0,0 +1,19 @@
+package types
+
+// PageRequest is the paging asked for by the caller. Before and After are
+// cursors in Unix milliseconds and only apply to recently played tracks.
+type PageRequest struct {
+	Limit  int
+	Offset int
+	Before int64
+	After  int64
+}
+
+// Page is the envelope of paginated responses. Next holds the query string
+// requesting the following page and is empty on the last page. Total is only
+// known for offset based endpoints.
+type Page[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next,omitempty"`
+	Total int    `json:"total,omitempty"`
+}
@@ -0,0 +1,101 @@
+package types
+
+import (
+	"strings"
+	"time"
+)
+
+// PlaySource tells where a play record was collected from.
+type PlaySource string
+
+const (
+	PlaySourceSpotify PlaySource = "spotify"
+	// PlaySourceExport marks plays imported from a Spotify data export.
+	PlaySourceExport PlaySource = "spotify_export"
+	PlaySourceLastFM PlaySource = "lastfm"
+)
+
+// Play is a single listen of a track. A user cannot play two tracks at the
+// same instant, so PlayedAt identifies a play within a user's history.
+type Play struct {
+	PlayedAt    time.Time `json:"playedAt"`
+	TrackID     string    `json:"trackId,omitempty"`
+	TrackName   string    `json:"trackName"`
+	ArtistIDs   []string  `json:"artistIds,omitempty"`
+	ArtistNames []string  `json:"artistNames"`
+	AlbumName   string    `json:"albumName,omitempty"`
+	DurationMs  int       `json:"durationMs,omitempty"`
+	// MsPlayed is how long the track was listened to, only known for
+	// imported plays.
+	MsPlayed int        `json:"msPlayed,omitempty"`
+	Source   PlaySource `json:"source"`
+}
+
+// PlayQuery filters a user's play history. Artist and Track match either the
+// Spotify ID or the name, ignoring case. Zero values do not filter.
+type PlayQuery struct {
+	From   time.Time
+	To     time.Time
+	Artist string
+	Track  string
+	Limit  int
+}
+
+func (q PlayQuery) Matches(p Play) bool {
+	if !q.From.IsZero() && p.PlayedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && p.PlayedAt.After(q.To) {
+		return false
+	}
+	if q.Track != "" && q.Track != p.TrackID && !strings.EqualFold(q.Track, p.TrackName) {
+		return false
+	}
+	if q.Artist != "" && !matchesAny(q.Artist, p.ArtistIDs, p.ArtistNames) {
+		return false
+	}
+	return true
+}
+
+func matchesAny(artist string, ids []string, names []string) bool {
+	for _, id := range ids {
+		if id == artist {
+			return true
+		}
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, artist) {
+			return true
+		}
+	}
+	return false
+}
+
+// TopTrack is a track ranked by how often it was played in a user's history.
+type TopTrack struct {
+	TrackID     string   `json:"trackId,omitempty"`
+	TrackName   string   `json:"trackName"`
+	ArtistNames []string `json:"artistNames"`
+	AlbumName   string   `json:"albumName,omitempty"`
+	Plays       int      `json:"plays"`
+}
+
+// TopArtist is an artist ranked by how often they were played in a user's
+// history. Imported plays carry no artist ID, so artists are told apart by name.
+type TopArtist struct {
+	ArtistName string `json:"artistName"`
+	Plays      int    `json:"plays"`
+}
+
+// HistoryImport reports the outcome of importing a streaming history file.
+type HistoryImport struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+}
+
+// LastFMLink ties a Spotify user to the last.fm account their scrobbles are
+// imported from. ImportedUntil is where the next import picks up.
+type LastFMLink struct {
+	Username      string    `json:"username"`
+	ImportedUntil time.Time `json:"importedUntil,omitempty"`
+}
@@ -6,4 +6,24 @@ type Song struct {
 	Detail          spotify.FullTrack     `json:"detail"`
 	Features        spotify.AudioFeatures `json:"features"`
 	Recommendations []spotify.SimpleTrack `json:"recommendations"`
+	LastFM          *TrackStats           `json:"lastfm,omitempty"`
+	// UserStats is only set for callers with a linked last.fm account and is
+	// never part of the cached song.
+	UserStats *UserTrackStats `json:"userStats,omitempty"`
+}
+
+// TrackStats is what last.fm knows about a track across all its listeners.
+type TrackStats struct {
+	URL         string   `json:"url"`
+	Listeners   int      `json:"listeners"`
+	Playcount   int      `json:"playcount"`
+	Tags        []string `json:"tags"`
+	WikiSummary string   `json:"wikiSummary,omitempty"`
+}
+
+// UserTrackStats is how a linked last.fm account listened to a track.
+type UserTrackStats struct {
+	Username  string `json:"username"`
+	Playcount int    `json:"playcount"`
+	Loved     bool   `json:"loved"`
 }